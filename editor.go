@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// errInterrupted is returned by an editor when the user presses Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads lines of REPL input.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	Close() error
+}
+
+// scannerReader reads plain lines, used when stdin is not a terminal.
+type scannerReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r scannerReader) ReadLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+
+	if !r.scanner.Scan() {
+		err := r.scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+
+		return "", err
+	}
+
+	return r.scanner.Text(), nil
+}
+
+func (_ scannerReader) Close() error {
+	return nil
+}
+
+// editor is a line editor with history and tab completion for terminals.
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	history  []string
+	histFile string
+	complete func() []string
+
+	prompt string
+	line   []rune
+	cursor int
+}
+
+// newLineReader returns an editor if stdin is a terminal, and a plain line
+// reader otherwise. complete should return every name available for
+// completion.
+func newLineReader(complete func() []string) lineReader {
+	fd := int(os.Stdin.Fd())
+	if !isTerminal(fd) {
+		return scannerReader{bufio.NewScanner(os.Stdin)}
+	}
+
+	e := &editor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		fd:       fd,
+		complete: complete,
+	}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		e.histFile = filepath.Join(home, ".clisp_history")
+		e.loadHistory()
+	}
+
+	return e
+}
+
+func (e *editor) loadHistory() {
+	data, err := os.ReadFile(e.histFile)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+
+	// The file grows past maxHistory if the REPL doesn't exit cleanly.
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+
+	e.appendHistory(line)
+}
+
+// appendHistory adds line to the history file straight away, so it is kept
+// even if the REPL is killed. Failing to write the history isn't worth
+// interrupting the user for, so errors are ignored.
+func (e *editor) appendHistory(line string) {
+	if e.histFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(e.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
+
+// Close rewrites the history file with the last maxHistory lines only.
+func (e *editor) Close() error {
+	if e.histFile == "" {
+		return nil
+	}
+
+	return os.WriteFile(e.histFile, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+}
+
+func (e *editor) ReadLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e.prompt = prompt
+	e.line = e.line[:0]
+	e.cursor = 0
+	e.refresh()
+
+	// Index into the history while browsing it, and the line being edited
+	// before browsing started.
+	histIndex := len(e.history)
+	pending := ""
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(e.line)
+			e.addHistory(line)
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+
+			e.delete()
+		case 1: // Ctrl-A
+			e.cursor = 0
+		case 5: // Ctrl-E
+			e.cursor = len(e.line)
+		case 2: // Ctrl-B
+			e.moveLeft()
+		case 6: // Ctrl-F
+			e.moveRight()
+		case 11: // Ctrl-K
+			e.line = e.line[:e.cursor]
+		case 21: // Ctrl-U
+			e.line = append(e.line[:0], e.line[e.cursor:]...)
+			e.cursor = 0
+		case 127, 8: // Backspace
+			if e.cursor > 0 {
+				e.cursor--
+				e.delete()
+			}
+		case '\t':
+			e.completeWord()
+		case 27: // Escape sequence
+			switch e.readEscape() {
+			case "[A", "OA":
+				if histIndex > 0 {
+					if histIndex == len(e.history) {
+						pending = string(e.line)
+					}
+
+					histIndex--
+					e.setLine(e.history[histIndex])
+				}
+			case "[B", "OB":
+				if histIndex < len(e.history) {
+					histIndex++
+
+					if histIndex == len(e.history) {
+						e.setLine(pending)
+					} else {
+						e.setLine(e.history[histIndex])
+					}
+				}
+			case "[C", "OC":
+				e.moveRight()
+			case "[D", "OD":
+				e.moveLeft()
+			case "[H", "OH", "[1~":
+				e.cursor = 0
+			case "[F", "OF", "[4~":
+				e.cursor = len(e.line)
+			case "[3~":
+				e.delete()
+			}
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				e.insert(r)
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// readEscape reads the rest of an escape sequence after ESC.
+func (e *editor) readEscape() string {
+	seq := ""
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return seq
+		}
+
+		seq += string(r)
+
+		// CSI sequences end with a byte in @-~, others are one character long.
+		if len(seq) > 1 && r >= '@' && r <= '~' {
+			return seq
+		}
+
+		if len(seq) == 1 && r != '[' && r != 'O' {
+			return seq
+		}
+	}
+}
+
+func (e *editor) setLine(line string) {
+	e.line = []rune(line)
+	e.cursor = len(e.line)
+}
+
+func (e *editor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.cursor+1:], e.line[e.cursor:])
+	e.line[e.cursor] = r
+	e.cursor++
+}
+
+func (e *editor) delete() {
+	if e.cursor < len(e.line) {
+		e.line = append(e.line[:e.cursor], e.line[e.cursor+1:]...)
+	}
+}
+
+func (e *editor) moveLeft() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+}
+
+func (e *editor) moveRight() {
+	if e.cursor < len(e.line) {
+		e.cursor++
+	}
+}
+
+// refresh redraws the prompt and line, and places the cursor.
+func (e *editor) refresh() {
+	fmt.Fprintf(e.out, "\r%v%v\x1b[K\r", e.prompt, string(e.line))
+
+	column := utf8.RuneCountInString(e.prompt) + e.cursor
+	if column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%vC", column)
+	}
+}
+
+// isIdentifierRune reports whether r can be part of an identifier.
+func isIdentifierRune(r rune) bool {
+	return r < utf8.RuneSelf && !strings.ContainsRune(" \t\r\n(){}\";", r)
+}
+
+// completeWord completes the identifier before the cursor. If there are
+// several candidates, their common prefix is inserted, or they are listed if
+// there is none to insert.
+func (e *editor) completeWord() {
+	start := e.cursor
+	for start > 0 && isIdentifierRune(e.line[start-1]) {
+		start--
+	}
+
+	prefix := string(e.line[start:e.cursor])
+	candidates := make([]string, 0)
+
+	for _, name := range e.complete() {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	sort.Strings(candidates)
+
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(candidates) == 1 {
+		common += " "
+	}
+
+	if common != prefix {
+		for _, r := range common[len(prefix):] {
+			e.insert(r)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(e.out, "\r\n%v\r\n", strings.Join(candidates, "  "))
+}
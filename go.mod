@@ -1 +1,3 @@
-module "lisp"
\ No newline at end of file
+module "lisp"
+
+go 1.16
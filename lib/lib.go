@@ -0,0 +1,14 @@
+// Package lib holds the clisp libraries that are built into the interpreter.
+package lib
+
+import (
+	_ "embed"
+)
+
+// StdFile is the name errors in the standard library are reported under.
+const StdFile = "lib/std.clsp"
+
+// Std is the source of the standard library.
+//
+//go:embed std.clsp
+var Std string
@@ -1,33 +1,171 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"lisp/lisp"
 	"os"
+	"strings"
 )
 
-func main() {
+const (
+	prompt             = "> "
+	continuationPrompt = ". "
+)
+
+// printError prints err to w. If err points into source, which was read from
+// file, the offending line is shown with a caret under the column.
+func printError(w io.Writer, file string, source string, err error) {
+	pos, ok := lisp.ErrorPosition(err)
+	lines := strings.Split(source, "\n")
+
+	if ok && pos.File == file && pos.Line <= len(lines) {
+		line := strings.TrimRight(lines[pos.Line-1], "\r")
+		indent := ""
+
+		for i, r := range []rune(line) {
+			if i >= pos.Column-1 {
+				break
+			}
+
+			if r == '\t' {
+				indent += "\t"
+			} else {
+				indent += " "
+			}
+		}
+
+		fmt.Fprintln(w, strings.Repeat(" ", len(prompt))+line)
+		fmt.Fprintln(w, strings.Repeat(" ", len(prompt))+indent+"^")
+	}
+
+	fmt.Fprintln(w, err)
+}
+
+// runScript evaluates the file at path and reports whether it succeeded.
+func runScript(env *lisp.Environment, path string) bool {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	_, err = lisp.EvaluateSource(env, path, string(source), true)
+	if err != nil {
+		printError(os.Stderr, path, string(source), err)
+		return false
+	}
+
+	return true
+}
+
+// runExpression evaluates input like the REPL does, prints the result and
+// reports whether it succeeded.
+func runExpression(env *lisp.Environment, input string) bool {
+	out, err := lisp.EvaluateInput(env, input)
+	if err != nil {
+		printError(os.Stderr, "", input, err)
+		return false
+	}
+
+	fmt.Println(out)
+	return true
+}
+
+// evaluateInput evaluates a complete REPL input and prints the result of the
+// last expression.
+func evaluateInput(env *lisp.Environment, input string) {
+	out, err := lisp.EvaluateInput(env, input)
+	if err != nil {
+		printError(os.Stdout, "", input, err)
+		return
+	}
+
+	fmt.Println(out)
+}
+
+func repl(env *lisp.Environment) {
 	fmt.Println("clisp REPL (Ctrl-C to exit)")
 
-	scanner := bufio.NewScanner(os.Stdin)
+	reader := newLineReader(func() []string {
+		names := make([]string, 0)
+		for _, name := range env.Names() {
+			names = append(names, string(name))
+		}
 
-	env := lisp.NewEnvironment(nil)
-	env.AddBuiltins()
+		return names
+	})
+	defer reader.Close()
 
 	for {
-		fmt.Print("> ")
-
-		if !scanner.Scan() {
+		input, err := reader.ReadLine(prompt)
+		if err != nil {
 			return
 		}
 
-		input := scanner.Text()
-		out, err := lisp.Evaluate(&env, input, false)
+		complete := true
+		for lisp.Incomplete(input) {
+			line, err := reader.ReadLine(continuationPrompt)
+			if err == errInterrupted {
+				complete = false
+				break
+			} else if err != nil {
+				return
+			}
+
+			input += "\n" + line
+		}
+
+		if complete {
+			evaluateInput(env, input)
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: clisp [-no-std] [-e expression] [script.clsp [args...]]")
+		flag.PrintDefaults()
+	}
+
+	expression := flag.String("e", "", "evaluate `expression`, print the result and exit")
+	noStd := flag.Bool("no-std", false, "don't load the standard library")
+	flag.Parse()
+
+	env := lisp.NewEnvironment(nil)
+	env.AddBuiltins()
+
+	if !*noStd {
+		err := env.LoadStdlib()
 		if err != nil {
-			fmt.Println(err)
-		} else {
-			fmt.Println(out)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	args := flag.Args()
+	if *expression == "" && len(args) > 0 {
+		args = args[1:]
+	}
+
+	nodes := make([]lisp.Node, len(args))
+	for i, arg := range args {
+		nodes[i] = lisp.StringNode(arg)
+	}
+
+	env.Def("args", lisp.ExpressionNode{Type: lisp.QExpression, Nodes: nodes})
+
+	switch {
+	case *expression != "":
+		if !runExpression(&env, *expression) {
+			os.Exit(1)
+		}
+	case flag.NArg() > 0:
+		if !runScript(&env, flag.Arg(0)) {
+			os.Exit(1)
 		}
+	default:
+		repl(&env)
 	}
 }
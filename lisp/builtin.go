@@ -1,12 +1,14 @@
 package lisp
 
 import (
-	"errors"
 	"fmt"
-	"math"
-	"os"
+	"reflect"
+	"sort"
+	"unicode/utf8"
 )
 
+// Builtin is a function implemented in Go. Builtins that end by evaluating an
+// expression return a tail call, which the evaluator continues with.
 type Builtin func(*Environment, []Node) Node
 
 type IncorrectType struct {
@@ -18,203 +20,287 @@ func (i IncorrectType) Error() string {
 	return fmt.Sprintf("expected %v, got %v", i.Expected, i.Actual)
 }
 
-func Add(_ *Environment, args []Node) Node {
-	sum := 0.0
-	for _, node := range args {
-		n, ok := node.(NumberNode)
-		if !ok {
-			return ErrorNode{IncorrectType{"Number", node.TypeString()}}
-		}
-		sum += float64(n)
+// TypeError is returned by builtins given a value of the wrong type. It wraps
+// an IncorrectType describing the mismatch.
+type TypeError struct {
+	IncorrectType
+}
+
+func (e TypeError) Unwrap() error {
+	return e.IncorrectType
+}
+
+func typeError(expected string, actual Node) TypeError {
+	return TypeError{IncorrectType{expected, actual.TypeString()}}
+}
+
+// variadic is the maximum number of arguments of builtins that take any
+// number of them.
+const variadic = -1
+
+// ArityError is returned by builtins given the wrong number of arguments. Max
+// is variadic if there is no maximum.
+type ArityError struct {
+	Min    int
+	Max    int
+	Actual int
+}
+
+func (e ArityError) Error() string {
+	var expected string
+	switch {
+	case e.Max == variadic:
+		expected = fmt.Sprintf("%v or more arguments", e.Min)
+	case e.Min == e.Max && e.Min == 1:
+		expected = "1 argument"
+	case e.Min == e.Max:
+		expected = fmt.Sprintf("%v arguments", e.Min)
+	case e.Min+1 == e.Max:
+		expected = fmt.Sprintf("%v or %v arguments", e.Min, e.Max)
+	default:
+		expected = fmt.Sprintf("%v to %v arguments", e.Min, e.Max)
 	}
-	return NumberNode(sum)
+
+	return fmt.Sprintf("expected %v, got %v", expected, e.Actual)
 }
 
-func Sub(_ *Environment, args []Node) Node {
-	if len(args) == 1 {
-		n, ok := args[0].(NumberNode)
-		if !ok {
-			return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
-		}
-		return -n
+// checkArity returns an ArityError unless there are between min and max args.
+func checkArity(args []Node, min int, max int) error {
+	if len(args) < min || (max != variadic && len(args) > max) {
+		return ArityError{min, max, len(args)}
 	}
 
-	sum, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+	return nil
+}
+
+// DomainError is returned by builtins given a value of the right type that
+// they can't handle, like a zero divisor or an index out of range.
+type DomainError struct {
+	Message string
+}
+
+func (e DomainError) Error() string {
+	return e.Message
+}
+
+// Truthy reports whether node counts as true in a condition. false, zero,
+// empty strings and empty expressions are false, anything else is true.
+func Truthy(node Node) bool {
+	switch v := node.(type) {
+	case BoolNode:
+		return bool(v)
+	case IntNode, BigIntNode, RatNode, FloatNode:
+		return !isZero(v)
+	case StringNode:
+		return v != ""
+	case ExpressionNode:
+		return len(v.Nodes) != 0
+	case MapNode:
+		return v.Len() != 0
+	default:
+		return true
 	}
+}
 
+// arithmetic folds args from left to right with op.
+func arithmetic(env *Environment, op byte, args []Node) Node {
+	for _, node := range args {
+		if !isNumber(node) {
+			return ErrorNode{typeError("Number", node)}
+		}
+	}
+
+	ret := args[0]
 	for _, node := range args[1:] {
-		n, ok := node.(NumberNode)
-		if !ok {
-			return ErrorNode{IncorrectType{"Number", node.TypeString()}}
+		ret = calculate(op, ret, node)
+
+		_, ok := ret.(ErrorNode)
+		if ok {
+			return ret
+		}
+
+		if err := env.checkNumber(ret); err != nil {
+			return ErrorNode{err}
 		}
-		sum -= n
 	}
 
-	return sum
+	return ret
 }
 
-func Mul(_ *Environment, args []Node) Node {
-	sum, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+func Add(env *Environment, args []Node) Node {
+	if len(args) == 0 {
+		return IntNode(0)
 	}
 
-	for _, node := range args[1:] {
-		n, ok := node.(NumberNode)
-		if !ok {
-			return ErrorNode{IncorrectType{"Number", node.TypeString()}}
-		}
-		sum *= n
+	return arithmetic(env, '+', args)
+}
+
+func Sub(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
 	}
 
-	return sum
+	if len(args) == 1 {
+		return arithmetic(env, '-', []Node{IntNode(0), args[0]})
+	}
+
+	return arithmetic(env, '-', args)
 }
 
-func Div(_ *Environment, args []Node) Node {
-	sum, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+func Mul(env *Environment, args []Node) Node {
+	if len(args) == 0 {
+		return IntNode(1)
 	}
 
-	for _, node := range args[1:] {
-		n, ok := node.(NumberNode)
-		if !ok {
-			return ErrorNode{IncorrectType{"Number", node.TypeString()}}
-		}
-		sum /= n
+	return arithmetic(env, '*', args)
+}
+
+func Div(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
 	}
 
-	return sum
+	if len(args) == 1 {
+		return arithmetic(env, '/', []Node{IntNode(1), args[0]})
+	}
+
+	return arithmetic(env, '/', args)
 }
 
 func Head(_ *Environment, args []Node) Node {
-	if len(args) > 1 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
 	switch v := args[0].(type) {
 	case ExpressionNode:
 		if v.Type != QExpression {
-			return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
 		}
 
 		if len(v.Nodes) == 0 {
-			return ErrorNode{errors.New("cannot take head of empty list")}
+			return ErrorNode{DomainError{"cannot take head of empty list"}}
 		}
 
-		return ExpressionNode{QExpression, v.Nodes[:1]}
+		return ExpressionNode{Type: QExpression, Nodes: v.Nodes[:1]}
 	case StringNode:
 		if len(v) == 0 {
-			return ErrorNode{errors.New("cannot take head of empty string")}
+			return ErrorNode{DomainError{"cannot take head of empty string"}}
 		}
 
-		return v[:1]
+		_, size := utf8.DecodeRuneInString(string(v))
+		return v[:size]
 	default:
-		return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
 	}
 }
 
 func Tail(_ *Environment, args []Node) Node {
-	if len(args) > 1 {
-		return ErrorNode{errors.New(fmt.Sprintf("expected 1 argument, got %v", len(args)))}
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
 	switch v := args[0].(type) {
 	case ExpressionNode:
 		if v.Type != QExpression {
-			return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
 		}
 
 		if len(v.Nodes) == 0 {
-			return ErrorNode{errors.New("cannot take tail of empty list")}
+			return ErrorNode{DomainError{"cannot take tail of empty list"}}
 		}
 
-		return ExpressionNode{QExpression, v.Nodes[1:]}
+		return ExpressionNode{Type: QExpression, Nodes: v.Nodes[1:]}
 	case StringNode:
 		if len(v) == 0 {
-			return ErrorNode{errors.New("cannot take tail of empty string")}
+			return ErrorNode{DomainError{"cannot take tail of empty string"}}
 		}
 
-		return v[1:]
+		_, size := utf8.DecodeRuneInString(string(v))
+		return v[size:]
 	default:
-		return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
 	}
 }
 
 func Post(_ *Environment, args []Node) Node {
-	if len(args) > 1 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
 	switch v := args[0].(type) {
 	case ExpressionNode:
 		if v.Type != QExpression {
-			return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
 		}
 
 		if len(v.Nodes) == 0 {
-			return ErrorNode{errors.New("cannot take post of empty list")}
+			return ErrorNode{DomainError{"cannot take post of empty list"}}
 		}
 
-		return ExpressionNode{QExpression, v.Nodes[len(v.Nodes)-1:]}
+		return ExpressionNode{Type: QExpression, Nodes: v.Nodes[len(v.Nodes)-1:]}
 	case StringNode:
 		if len(v) == 0 {
-			return ErrorNode{errors.New("cannot take post of empty string")}
+			return ErrorNode{DomainError{"cannot take post of empty string"}}
 		}
 
-		return v[len(v)-1:]
+		_, size := utf8.DecodeLastRuneInString(string(v))
+		return v[len(v)-size:]
 	default:
-		return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
 	}
 }
 
 func Init(_ *Environment, args []Node) Node {
-	if len(args) > 1 {
-		return ErrorNode{errors.New(fmt.Sprintf("expected 1 argument, got %v", len(args)))}
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
 	switch v := args[0].(type) {
 	case ExpressionNode:
 		if v.Type != QExpression {
-			return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
 		}
 
 		if len(v.Nodes) == 0 {
-			return ErrorNode{errors.New("cannot take init of empty list")}
+			return ErrorNode{DomainError{"cannot take init of empty list"}}
 		}
 
-		return ExpressionNode{QExpression, v.Nodes[:len(v.Nodes)-1]}
+		return ExpressionNode{Type: QExpression, Nodes: v.Nodes[:len(v.Nodes)-1]}
 	case StringNode:
 		if len(v) == 0 {
-			return ErrorNode{errors.New("cannot take init of empty string")}
+			return ErrorNode{DomainError{"cannot take init of empty string"}}
 		}
 
-		return v[:len(v)-1]
+		_, size := utf8.DecodeLastRuneInString(string(v))
+		return v[:len(v)-size]
 	default:
-		return ErrorNode{IncorrectType{"Q-Expression or String", args[0].TypeString()}}
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
 	}
 }
 
 func List(_ *Environment, args []Node) Node {
-	return ExpressionNode{QExpression, args}
+	return ExpressionNode{Type: QExpression, Nodes: args}
 }
 
 func Eval(env *Environment, args []Node) Node {
-	if len(args) > 1 {
-		return ErrorNode{errors.New(fmt.Sprintf("expected 1 argument, got %v", len(args)))}
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
 	expr, ok := args[0].(ExpressionNode)
-	if ok {
-		return expr.EvalAsSExpr(env)
+	if ok && expr.Type != MapExpression {
+		return tailCall{expr, env}
 	} else {
 		return args[0].Evaluate(env)
 	}
 }
 
-func Join(_ *Environment, args []Node) Node {
+func Join(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
 	switch v := args[0].(type) {
 	case ExpressionNode:
 		nodes := make([]Node, 0)
@@ -222,299 +308,806 @@ func Join(_ *Environment, args []Node) Node {
 		for _, n := range args {
 			expr, ok := n.(ExpressionNode)
 			if !ok || expr.Type != QExpression {
-				return ErrorNode{IncorrectType{"Q-Expression", n.TypeString()}}
+				return ErrorNode{typeError("Q-Expression", n)}
+			}
+
+			if err := env.checkLength(len(nodes) + len(expr.Nodes)); err != nil {
+				return ErrorNode{err}
 			}
+
 			nodes = append(nodes, expr.Nodes...)
 		}
 
-		return ExpressionNode{QExpression, nodes}
+		return ExpressionNode{Type: QExpression, Nodes: nodes}
 	case StringNode:
 		ret := StringNode("")
 
 		for _, n := range args {
 			str, ok := n.(StringNode)
 			if !ok {
-				return ErrorNode{IncorrectType{"String", n.TypeString()}}
+				return ErrorNode{typeError("String", n)}
 			}
+
 			ret += str
+			if err := env.checkResult(ret); err != nil {
+				return ErrorNode{err}
+			}
 		}
 
 		return ret
 	default:
-		return ErrorNode{IncorrectType{"Q-Expression or String", v.TypeString()}}
+		return ErrorNode{typeError("Q-Expression or String", v)}
 	}
 }
 
-func val(env *Environment, args []Node, global bool) Node {
+// val binds the identifiers in the first argument to the other arguments, in
+// env.
+func val(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
 	expr, ok := args[0].(ExpressionNode)
 	if !ok || expr.Type != QExpression {
-		return ErrorNode{IncorrectType{"Q-Expression", args[0].TypeString()}}
+		return ErrorNode{typeError("Q-Expression", args[0])}
 	}
 
 	for _, node := range expr.Nodes {
 		_, ok := node.(IdentifierNode)
 		if !ok {
-			return ErrorNode{IncorrectType{"Identifier", node.TypeString()}}
+			return ErrorNode{typeError("Identifier", node)}
 		}
 	}
 
-	args = args[1:]
-	if len(args) != len(expr.Nodes) {
-		return ErrorNode{fmt.Errorf("expected %v arguments, got %v", len(expr.Nodes)+1, len(args)+1)}
+	if err := checkArity(args, len(expr.Nodes)+1, len(expr.Nodes)+1); err != nil {
+		return ErrorNode{err}
 	}
 
+	args = args[1:]
+
 	for i := range args {
-		if global {
-			env.Def(expr.Nodes[i].(IdentifierNode), args[i])
-		} else {
-			env.Put(expr.Nodes[i].(IdentifierNode), args[i])
+		fun, ok := args[i].(FunctionNode)
+		if ok && fun.Name == "" {
+			fun.Name = string(expr.Nodes[i].(IdentifierNode))
+			args[i] = fun
 		}
+
+		env.Put(expr.Nodes[i].(IdentifierNode), args[i])
 	}
 
 	return ExpressionNode{Type: SExpression}
 }
 
-func Let(env *Environment, args []Node) Node {
-	return val(env, args, false)
-}
-
-func Def(env *Environment, args []Node) Node {
-	return val(env, args, true)
-}
+// Set changes the values of variables that are already defined, in the scope
+// that defines them.
+func Set(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
+	}
 
-func Fn(_ *Environment, args []Node) Node {
-	if len(args) != 2 {
-		return ErrorNode{fmt.Errorf("expected 2 arguments, got %v", len(args))}
+	expr, ok := args[0].(ExpressionNode)
+	if !ok || expr.Type != QExpression {
+		return ErrorNode{typeError("Q-Expression", args[0])}
 	}
 
-	for _, n := range args {
-		expr, ok := n.(ExpressionNode)
-		if !ok || expr.Type != QExpression {
-			return ErrorNode{IncorrectType{"Q-Expression", n.TypeString()}}
-		}
+	if err := checkArity(args, len(expr.Nodes)+1, len(expr.Nodes)+1); err != nil {
+		return ErrorNode{err}
 	}
 
-	nodes := args[0].(ExpressionNode).Nodes
-	formals := make([]IdentifierNode, 0, len(nodes))
-	for _, node := range nodes {
-		i, ok := node.(IdentifierNode)
+	for _, node := range expr.Nodes {
+		_, ok := node.(IdentifierNode)
 		if !ok {
-			return ErrorNode{IncorrectType{"Identifier", node.TypeString()}}
+			return ErrorNode{typeError("Identifier", node)}
 		}
+	}
 
-		formals = append(formals, i)
+	for i, node := range expr.Nodes {
+		if !env.Set(node.(IdentifierNode), args[i+1]) {
+			return ErrorNode{DomainError{fmt.Sprintf("cannot set undefined identifier %v", node)}}
+		}
 	}
 
-	subEnv := NewEnvironment(nil)
+	return ExpressionNode{Type: SExpression}
+}
 
-	return FunctionNode{
-		Environment: &subEnv,
-		Formals:     formals,
-		Body:        args[1].(ExpressionNode),
-	}
+// Let defines values in the current scope, which inside a function is the
+// function's own scope.
+func Let(env *Environment, args []Node) Node {
+	return val(env, args)
 }
 
-func Import(env *Environment, args []Node) Node {
-	if len(args) != 1 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
-	}
+// Def defines values in the scope of the function it is called from, or in the
+// module at the top level, including inside nested scopes like the handler of
+// a try.
+func Def(env *Environment, args []Node) Node {
+	return val(env.scope(), args)
+}
 
-	path, ok := args[0].(StringNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"String", args[0].TypeString()}}
+func Fn(env *Environment, args []Node) Node {
+	return lambda(env, args, false)
+}
+
+func Equal(env *Environment, args []Node) Node {
+	if err := checkArity(args, 2, variadic); err != nil {
+		return ErrorNode{err}
 	}
 
-	file, err := os.ReadFile(string(path) + ".clsp")
-	if err == nil {
-		_, err := Evaluate(env, string(file), true)
+	for _, arg := range args[1:] {
+		eq, err := equal(env, args[0], arg)
 		if err != nil {
 			return ErrorNode{err}
 		}
+
+		if !eq {
+			return BoolNode(false)
+		}
 	}
 
-	return ExpressionNode{Type: SExpression}
+	return BoolNode(true)
 }
 
-func Equal(env *Environment, args []Node) Node {
-	if len(args) < 2 {
-		return ErrorNode{fmt.Errorf("expected 2 or more arguments, got %v", len(args))}
+// equal reports whether a and b are equal. Lists and maps are compared element
+// by element, functions are only equal to the same function. Comparing nested
+// values counts against the maximum depth of env.
+func equal(env *Environment, a Node, b Node) (bool, error) {
+	if err := env.state.enter(); err != nil {
+		return false, err
 	}
+	defer env.state.leave()
 
-	first := args[0]
+	switch first := a.(type) {
+	case IntNode, BigIntNode, RatNode, FloatNode:
+		return isNumber(b) && compareNumbers(a, b) == 0, nil
+	case BoolNode, StringNode, IdentifierNode:
+		return a == b, nil
+	case ExpressionNode:
+		expr, ok := b.(ExpressionNode)
+		if !ok || first.Type != expr.Type || len(first.Nodes) != len(expr.Nodes) {
+			return false, nil
+		}
 
-	for _, arg := range args[1:] {
-		switch first.(type) {
-		case NumberNode:
-			n, ok := arg.(NumberNode)
-			if !ok {
-				return NumberNode(0)
+		for i, node := range first.Nodes {
+			eq, err := equal(env, node, expr.Nodes[i])
+			if err != nil || !eq {
+				return false, err
 			}
+		}
 
-			if first != n {
-				return NumberNode(0)
-			}
-		case StringNode:
-			str, ok := arg.(StringNode)
-			if !ok {
-				return NumberNode(0)
-			}
+		return true, nil
+	case MapNode:
+		m, ok := b.(MapNode)
+		if !ok || first.Len() != m.Len() {
+			return false, nil
+		}
 
-			if first != str {
-				return NumberNode(0)
-			}
-		case ExpressionNode:
-			first := first.(ExpressionNode)
-			expr, ok := arg.(ExpressionNode)
+		for k, entry := range first.entries {
+			other, ok := m.entries[k]
 			if !ok {
-				return NumberNode(0)
+				return false, nil
 			}
 
-			if first.Type != expr.Type || len(first.Nodes) != len(expr.Nodes) {
-				return NumberNode(0)
+			eq, err := equal(env, entry.Value, other.Value)
+			if err != nil || !eq {
+				return false, err
 			}
+		}
 
-			for i, node := range first.Nodes {
-				if Equal(env, []Node{expr.Nodes[i], node}) == NumberNode(0) {
-					return NumberNode(0)
-				}
+		return true, nil
+	case FunctionNode:
+		fun, ok := b.(FunctionNode)
+		if !ok || first.Name != fun.Name || first.Macro != fun.Macro {
+			return false, nil
+		}
+
+		if first.Builtin != nil || fun.Builtin != nil {
+			return first.Builtin != nil && fun.Builtin != nil &&
+				reflect.ValueOf(first.Builtin).Pointer() == reflect.ValueOf(fun.Builtin).Pointer(), nil
+		}
+
+		if first.Environment != fun.Environment || len(first.Formals) != len(fun.Formals) {
+			return false, nil
+		}
+
+		for i, formal := range first.Formals {
+			if formal != fun.Formals[i] {
+				return false, nil
 			}
-		default:
-			return ErrorNode{fmt.Errorf("unimplemented equality for %v", first.TypeString())}
 		}
-	}
 
-	return NumberNode(1)
-}
+		return equal(env, first.Body, fun.Body)
+	case QuasiquoteNode:
+		q, ok := b.(QuasiquoteNode)
+		if !ok {
+			return false, nil
+		}
 
-func If(env *Environment, args []Node) Node {
-	if len(args) != 3 {
-		return ErrorNode{fmt.Errorf("expected 3 arguments, got %v", len(args))}
+		return equal(env, first.Node, q.Node)
+	case UnquoteNode:
+		u, ok := b.(UnquoteNode)
+		if !ok || first.Splice != u.Splice {
+			return false, nil
+		}
+
+		return equal(env, first.Node, u.Node)
+	case RefNode:
+		// References are only equal to themselves.
+		r, ok := b.(RefNode)
+		return ok && first.cell == r.cell, nil
+	case ErrorValueNode:
+		e, ok := b.(ErrorValueNode)
+		if !ok || first.Type != e.Type || first.Message != e.Message {
+			return false, nil
+		}
+
+		if first.Payload == nil || e.Payload == nil {
+			return first.Payload == nil && e.Payload == nil, nil
+		}
+
+		return equal(env, first.Payload, e.Payload)
+	default:
+		return false, DomainError{fmt.Sprintf("unimplemented equality for %v", a.TypeString())}
 	}
+}
 
-	condition, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+func If(env *Environment, args []Node) Node {
+	if err := checkArity(args, 3, 3); err != nil {
+		return ErrorNode{err}
 	}
 
 	yes, ok := args[1].(ExpressionNode)
 	if !ok || yes.Type != QExpression {
-		return ErrorNode{IncorrectType{"Q-Expression", args[1].TypeString()}}
+		return ErrorNode{typeError("Q-Expression", args[1])}
 	}
 
 	no, ok := args[2].(ExpressionNode)
 	if !ok || no.Type != QExpression {
-		return ErrorNode{IncorrectType{"Q-Expression", args[2].TypeString()}}
+		return ErrorNode{typeError("Q-Expression", args[2])}
 	}
 
-	if condition != NumberNode(0) {
-		return yes.EvalAsSExpr(env)
+	if Truthy(args[0]) {
+		return tailCall{yes, env}
 	} else {
-		return no.EvalAsSExpr(env)
+		return tailCall{no, env}
 	}
 }
 
-func Mod(_ *Environment, args []Node) Node {
-	if len(args) != 2 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+func Len(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
-	n, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+	switch v := args[0].(type) {
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression, String or Map", args[0])}
+		}
+
+		return IntNode(len(v.Nodes))
+	case StringNode:
+		return IntNode(utf8.RuneCountInString(string(v)))
+	case MapNode:
+		return IntNode(v.Len())
+	default:
+		return ErrorNode{typeError("Q-Expression, String or Map", args[0])}
 	}
+}
 
-	mod, ok := args[1].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[1].TypeString()}}
+// evalElement evaluates a list element in tail position, like eval does with
+// a single element list.
+func evalElement(env *Environment, node Node) Node {
+	return tailCall{ExpressionNode{Type: SExpression, Nodes: []Node{node}}, env}
+}
+
+// First returns the first element of a list, evaluated like the standard
+// library version using eval and head did.
+func First(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
-	return NumberNode(math.Mod(float64(n), float64(mod)))
+	switch v := args[0].(type) {
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
+		}
+
+		if len(v.Nodes) == 0 {
+			return ErrorNode{DomainError{"cannot take first of empty list"}}
+		}
+
+		return evalElement(env, v.Nodes[0])
+	case StringNode:
+		if len(v) == 0 {
+			return ErrorNode{DomainError{"cannot take first of empty string"}}
+		}
+
+		_, size := utf8.DecodeRuneInString(string(v))
+		return v[:size]
+	default:
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
+	}
 }
 
-func Less(_ *Environment, args []Node) Node {
-	if len(args) != 2 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+// Last returns the last element of a list, evaluated like First.
+func Last(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
-	n1, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+	switch v := args[0].(type) {
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
+		}
+
+		if len(v.Nodes) == 0 {
+			return ErrorNode{DomainError{"cannot take last of empty list"}}
+		}
+
+		return evalElement(env, v.Nodes[len(v.Nodes)-1])
+	case StringNode:
+		if len(v) == 0 {
+			return ErrorNode{DomainError{"cannot take last of empty string"}}
+		}
+
+		_, size := utf8.DecodeLastRuneInString(string(v))
+		return v[len(v)-size:]
+	default:
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
 	}
+}
 
-	n2, ok := args[1].(NumberNode)
+// count converts node to a non-negative number of elements.
+func count(node Node) (int, error) {
+	n, ok := node.(IntNode)
 	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[1].TypeString()}}
+		return 0, typeError("Integer", node)
 	}
 
-	if n1 < n2 {
-		return NumberNode(1)
-	} else {
-		return NumberNode(0)
+	if n < 0 {
+		return 0, DomainError{fmt.Sprintf("expected a non-negative count, got %v", n)}
 	}
+
+	return int(n), nil
 }
 
-func LessEqual(_ *Environment, args []Node) Node {
-	if len(args) != 2 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+// Nth returns the element of a list at a zero-based index. Unlike first and
+// last, the element is returned as-is without evaluating it.
+func Nth(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
 	}
 
-	n1, ok := args[0].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+	n, err := count(args[0])
+	if err != nil {
+		return ErrorNode{err}
 	}
 
-	n2, ok := args[1].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[1].TypeString()}}
+	switch v := args[1].(type) {
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression or String", args[1])}
+		}
+
+		if n >= len(v.Nodes) {
+			return ErrorNode{DomainError{fmt.Sprintf("index %v out of range for list of length %v", n, len(v.Nodes))}}
+		}
+
+		return v.Nodes[n]
+	case StringNode:
+		runes := []rune(v)
+		if n >= len(runes) {
+			return ErrorNode{DomainError{fmt.Sprintf("index %v out of range for string of length %v", n, len(runes))}}
+		}
+
+		return StringNode(runes[n : n+1])
+	default:
+		return ErrorNode{typeError("Q-Expression or String", args[1])}
 	}
+}
 
-	if n1 <= n2 {
-		return NumberNode(1)
-	} else {
-		return NumberNode(0)
+// slice implements take, drop and dropLast. It returns the elements of a list
+// or string between the bounds computed by bounds from its length.
+func slice(args []Node, bounds func(n int, length int) (int, int)) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	n, err := count(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	switch v := args[1].(type) {
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression or String", args[1])}
+		}
+
+		start, end := bounds(n, len(v.Nodes))
+		return ExpressionNode{Type: QExpression, Nodes: v.Nodes[start:end]}
+	case StringNode:
+		runes := []rune(v)
+		start, end := bounds(n, len(runes))
+		return StringNode(runes[start:end])
+	default:
+		return ErrorNode{typeError("Q-Expression or String", args[1])}
 	}
 }
 
-func More(_ *Environment, args []Node) Node {
-	if len(args) != 2 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+func Take(_ *Environment, args []Node) Node {
+	return slice(args, func(n int, length int) (int, int) {
+		if n > length {
+			return 0, length
+		}
+
+		return 0, n
+	})
+}
+
+func Drop(_ *Environment, args []Node) Node {
+	return slice(args, func(n int, length int) (int, int) {
+		if n > length {
+			return length, length
+		}
+
+		return n, length
+	})
+}
+
+func DropLast(_ *Environment, args []Node) Node {
+	return slice(args, func(n int, length int) (int, int) {
+		if n > length {
+			return 0, 0
+		}
+
+		return 0, length - n
+	})
+}
+
+func Reverse(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
 	}
 
-	n1, ok := args[0].(NumberNode)
+	switch v := args[0].(type) {
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression or String", args[0])}
+		}
+
+		nodes := make([]Node, len(v.Nodes))
+		for i, node := range v.Nodes {
+			nodes[len(nodes)-1-i] = node
+		}
+
+		return ExpressionNode{Type: QExpression, Nodes: nodes}
+	case StringNode:
+		runes := []rune(v)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+
+		return StringNode(runes)
+	default:
+		return ErrorNode{typeError("Q-Expression or String", args[0])}
+	}
+}
+
+// Range returns the integers from the first argument up to and including the
+// second.
+func Range(env *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	from, ok := args[0].(IntNode)
 	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+		return ErrorNode{typeError("Integer", args[0])}
 	}
 
-	n2, ok := args[1].(NumberNode)
+	to, ok := args[1].(IntNode)
 	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[1].TypeString()}}
+		return ErrorNode{typeError("Integer", args[1])}
 	}
 
-	if n1 > n2 {
-		return NumberNode(1)
-	} else {
-		return NumberNode(0)
+	if to < from {
+		return ExpressionNode{Type: QExpression, Nodes: make([]Node, 0)}
+	}
+
+	// The difference always fits in a uint64, even when to - from overflows.
+	diff := uint64(to) - uint64(from)
+	if diff >= uint64(maxInt) {
+		return ErrorNode{DomainError{fmt.Sprintf("range from %v to %v is too long", from, to)}}
+	}
+
+	count := int(diff) + 1
+	if err := env.checkLength(count); err != nil {
+		return ErrorNode{err}
 	}
+
+	// Huge ranges grow as they go instead, so the time limit can stop them
+	// before they run out of memory.
+	capacity := count
+	if capacity > 1<<20 {
+		capacity = 1 << 20
+	}
+
+	nodes := make([]Node, 0, capacity)
+	for i := 0; i < count; i++ {
+		if err := env.checkTime(i); err != nil {
+			return ErrorNode{err}
+		}
+
+		nodes = append(nodes, from+IntNode(i))
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
 }
 
-func MoreEqual(_ *Environment, args []Node) Node {
-	if len(args) != 2 {
-		return ErrorNode{fmt.Errorf("expected 1 argument, got %v", len(args))}
+// functionAndList checks that args are a function followed by a list, and
+// returns both.
+func functionAndList(args []Node) (FunctionNode, ExpressionNode, Node) {
+	if err := checkArity(args, 2, 2); err != nil {
+		return FunctionNode{}, ExpressionNode{}, ErrorNode{err}
 	}
 
-	n1, ok := args[0].(NumberNode)
+	f, ok := args[0].(FunctionNode)
 	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[0].TypeString()}}
+		return FunctionNode{}, ExpressionNode{}, ErrorNode{typeError("Function", args[0])}
 	}
 
-	n2, ok := args[1].(NumberNode)
-	if !ok {
-		return ErrorNode{IncorrectType{"Number", args[1].TypeString()}}
+	xs, ok := args[1].(ExpressionNode)
+	if !ok || xs.Type != QExpression {
+		return FunctionNode{}, ExpressionNode{}, ErrorNode{typeError("Q-Expression", args[1])}
 	}
 
-	if n1 >= n2 {
-		return NumberNode(1)
-	} else {
-		return NumberNode(0)
+	return f, xs, nil
+}
+
+func Map(env *Environment, args []Node) Node {
+	f, xs, err := functionAndList(args)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]Node, len(xs.Nodes))
+	for i, node := range xs.Nodes {
+		nodes[i] = f.call(env, Position{}, []Node{node})
+
+		_, ok := nodes[i].(ErrorNode)
+		if ok {
+			return nodes[i]
+		}
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+func Filter(env *Environment, args []Node) Node {
+	f, xs, err := functionAndList(args)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]Node, 0)
+	for _, node := range xs.Nodes {
+		keep := f.call(env, Position{}, []Node{node})
+
+		_, ok := keep.(ErrorNode)
+		if ok {
+			return keep
+		}
+
+		if Truthy(keep) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+// fold combines acc with every element of xs from left to right using f.
+func fold(env *Environment, f FunctionNode, acc Node, xs []Node) Node {
+	for _, node := range xs {
+		acc = f.call(env, Position{}, []Node{acc, node})
+
+		_, ok := acc.(ErrorNode)
+		if ok {
+			return acc
+		}
 	}
+
+	return acc
+}
+
+func Foldl(env *Environment, args []Node) Node {
+	if err := checkArity(args, 3, 3); err != nil {
+		return ErrorNode{err}
+	}
+
+	f, xs, err := functionAndList([]Node{args[0], args[2]})
+	if err != nil {
+		return err
+	}
+
+	return fold(env, f, args[1], xs.Nodes)
+}
+
+// Reduce folds a list like foldl, starting with its first element.
+func Reduce(env *Environment, args []Node) Node {
+	f, xs, err := functionAndList(args)
+	if err != nil {
+		return err
+	}
+
+	if len(xs.Nodes) == 0 {
+		return ErrorNode{DomainError{"cannot reduce empty list"}}
+	}
+
+	return fold(env, f, xs.Nodes[0], xs.Nodes[1:])
+}
+
+// Zip returns a list of lists, the nth holding the nth element of every
+// argument. It is as long as the shortest argument.
+func Zip(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
+	length := -1
+	for _, arg := range args {
+		xs, ok := arg.(ExpressionNode)
+		if !ok || xs.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression", arg)}
+		}
+
+		if length == -1 || len(xs.Nodes) < length {
+			length = len(xs.Nodes)
+		}
+	}
+
+	nodes := make([]Node, length)
+	for i := range nodes {
+		tuple := make([]Node, len(args))
+		for j, arg := range args {
+			tuple[j] = arg.(ExpressionNode).Nodes[i]
+		}
+
+		nodes[i] = ExpressionNode{Type: QExpression, Nodes: tuple}
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+// Sort sorts a list of numbers or strings. Given a function as well, it is
+// called with two elements and should return whether the first goes before the
+// second.
+func Sort(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	var f *FunctionNode
+	if len(args) == 2 {
+		fun, ok := args[0].(FunctionNode)
+		if !ok {
+			return ErrorNode{typeError("Function", args[0])}
+		}
+
+		f = &fun
+		args = args[1:]
+	}
+
+	xs, ok := args[0].(ExpressionNode)
+	if !ok || xs.Type != QExpression {
+		return ErrorNode{typeError("Q-Expression", args[0])}
+	}
+
+	nodes := append([]Node{}, xs.Nodes...)
+
+	if f == nil {
+		for _, node := range nodes {
+			_, isString := node.(StringNode)
+			if !isNumber(node) && !isString {
+				return ErrorNode{typeError("Number or String", node)}
+			}
+
+			// Numbers and strings can't be sorted together, so every element
+			// has to match the type of the first.
+			if _, first := nodes[0].(StringNode); isString != first {
+				if first {
+					return ErrorNode{typeError("String", node)}
+				}
+
+				return ErrorNode{typeError("Number", node)}
+			}
+		}
+	}
+
+	var failed Node
+	comparisons := 0
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+
+		comparisons++
+		if err := env.checkTime(comparisons); err != nil {
+			failed = ErrorNode{err}
+			return false
+		}
+
+		if f == nil {
+			if isNumber(nodes[i]) {
+				return compareNumbers(nodes[i], nodes[j]) < 0
+			}
+
+			return nodes[i].(StringNode) < nodes[j].(StringNode)
+		}
+
+		less := f.call(env, Position{}, []Node{nodes[i], nodes[j]})
+
+		_, ok := less.(ErrorNode)
+		if ok {
+			failed = less
+			return false
+		}
+
+		return Truthy(less)
+	})
+
+	if failed != nil {
+		return failed
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+func Bool(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	return BoolNode(Truthy(args[0]))
+}
+
+func Mod(env *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	return arithmetic(env, '%', args)
+}
+
+// comparison compares two numbers, and returns whether the result of
+// compareNumbers satisfies check.
+func comparison(args []Node, check func(int) bool) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	for _, node := range args {
+		if !isNumber(node) {
+			return ErrorNode{typeError("Number", node)}
+		}
+	}
+
+	return BoolNode(check(compareNumbers(args[0], args[1])))
+}
+
+func Less(_ *Environment, args []Node) Node {
+	return comparison(args, func(c int) bool { return c < 0 })
+}
+
+func LessEqual(_ *Environment, args []Node) Node {
+	return comparison(args, func(c int) bool { return c <= 0 })
+}
+
+func More(_ *Environment, args []Node) Node {
+	return comparison(args, func(c int) bool { return c > 0 })
+}
+
+func MoreEqual(_ *Environment, args []Node) Node {
+	return comparison(args, func(c int) bool { return c >= 0 })
 }
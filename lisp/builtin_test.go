@@ -0,0 +1,98 @@
+package lisp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEqual(t *testing.T) {
+	runTests(t, []evalTest{
+		{`= {a} {a}`, "true"},
+		{`= {a} {b}`, "false"},
+		{`= {1 {a b}} {1 {a b}}`, "true"},
+		{`= {1 {a b}} {1 {a c}}`, "false"},
+		{`= (list +) (list +)`, "true"},
+		{`= (list +) (list -)`, "false"},
+		{`= #{"a" {x}} #{"a" {x}}`, "true"},
+		{`= #{"a" {x}} #{"a" {y}}`, "false"},
+		{`(def {f} (fn {x} {x})) (= f f)`, "true"},
+		{`= (fn {x} {x}) (fn {x} {y})`, "false"},
+		{`= 1 1.0`, "true"},
+		{`= "a" {a}`, "false"},
+	})
+}
+
+func TestRange(t *testing.T) {
+	runTests(t, []evalTest{
+		{`range -3 2`, "{-3 -2 -1 0 1 2}"},
+		{`range 3 2`, "{}"},
+		{`range 9223372036854775806 9223372036854775807`, "{9223372036854775806 9223372036854775807}"},
+		{`try {range -9223372036854775808 9223372036854775807} {e} {error-type e}`, `"domain"`},
+	})
+}
+
+// TestDeeplyNestedValues checks that comparing, printing and filling in values
+// nested far deeper than the maximum depth fails cleanly, or works without
+// recursing.
+func TestParseDeeplyNested(t *testing.T) {
+	const depth = 40000
+
+	start := time.Now()
+	expr, err := ParseSource("", strings.Repeat("{", depth)+"1"+strings.Repeat("}", depth), SExpression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("parsing took %v", time.Since(start))
+	}
+
+	if expr.String() != "("+strings.Repeat("{", depth)+"1"+strings.Repeat("}", depth)+")" {
+		t.Errorf("parsed as %.20v...", expr)
+	}
+
+	_, err = ParseSource("", strings.Repeat("{", depth), SExpression)
+
+	var eoi UnexpectedEOI
+	if !errors.As(err, &eoi) {
+		t.Errorf("got %v, want UnexpectedEOI", err)
+	}
+}
+
+func TestDeeplyNestedValues(t *testing.T) {
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const depth = 1000000
+
+	var deep Node = IntNode(1)
+	for i := 0; i < depth; i++ {
+		deep = ExpressionNode{Type: QExpression, Nodes: []Node{deep}}
+	}
+
+	env.Put("deep", deep)
+
+	_, err = EvaluateInput(&env, "= deep deep")
+
+	var maxDepth MaxDepthExceeded
+	if !errors.As(err, &maxDepth) {
+		t.Errorf("= deep deep: got %v, want MaxDepthExceeded", err)
+	}
+
+	if deep.String() != strings.Repeat("{", depth)+"1"+strings.Repeat("}", depth) {
+		t.Errorf("deep printed as %.20v...", deep)
+	}
+
+	node, ok := quasiquote(&env, deep).(ErrorNode)
+	if !ok || !errors.As(node.Error, &maxDepth) {
+		t.Errorf("quasiquote: got %.20v, want MaxDepthExceeded", node)
+	}
+
+	if env.state.depth != 0 {
+		t.Errorf("depth is %v after failing", env.state.depth)
+	}
+}
@@ -0,0 +1,440 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	envType   = reflect.TypeOf((*Environment)(nil))
+)
+
+// UnsupportedType is returned when a Go value can't be converted to a node,
+// or a node to a Go value of a type.
+type UnsupportedType struct {
+	Type reflect.Type
+}
+
+func (e UnsupportedType) Error() string {
+	return fmt.Sprintf("cannot convert between %v and clisp values", e.Type)
+}
+
+// ToNode converts a Go value to a node. Numbers, strings and booleans become
+// the matching node, slices and arrays Q-Expressions, maps MapNodes and
+// functions builtins, like for Register. An error becomes an ErrorNode and
+// nil the empty S-Expression. Nodes are returned as is.
+func ToNode(value interface{}) (Node, error) {
+	switch v := value.(type) {
+	case nil:
+		return ExpressionNode{Type: SExpression}, nil
+	case Node:
+		return v, nil
+	case error:
+		return ErrorNode{v}, nil
+	case *big.Int:
+		return bigIntNode(new(big.Int).Set(v)), nil
+	case *big.Rat:
+		return ratNode(new(big.Rat).Set(v)), nil
+	}
+
+	return toNode(reflect.ValueOf(value))
+}
+
+func toNode(v reflect.Value) (Node, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return BoolNode(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return IntNode(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return bigIntNode(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return FloatNode(v.Float()), nil
+	case reflect.String:
+		return StringNode(v.String()), nil
+	case reflect.Slice, reflect.Array:
+		nodes := make([]Node, v.Len())
+		for i := range nodes {
+			node, err := ToNode(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			nodes[i] = node
+		}
+
+		return ExpressionNode{Type: QExpression, Nodes: nodes}, nil
+	case reflect.Map:
+		ret := NewMap()
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := ToNode(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := ToNode(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			k, err := mapKey(key)
+			if err != nil {
+				return nil, err
+			}
+
+			ret.entries[k] = mapEntry{key, value}
+		}
+
+		return ret, nil
+	case reflect.Func:
+		builtin, err := wrapFunc(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return FunctionNode{Builtin: builtin}, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ExpressionNode{Type: SExpression}, nil
+		}
+
+		return ToNode(v.Elem().Interface())
+	default:
+		return nil, UnsupportedType{v.Type()}
+	}
+}
+
+// FromNode converts node to a Go value of type t. Functions become Go
+// functions that call them in env.
+func FromNode(env *Environment, node Node, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		return reflect.ValueOf(natural(node)), nil
+	}
+
+	if reflect.TypeOf(node).AssignableTo(t) {
+		return reflect.ValueOf(node).Convert(t), nil
+	}
+
+	if t == errorType {
+		err, ok := node.(ErrorNode)
+		if !ok {
+			return reflect.Value{}, typeError("Error", node)
+		}
+
+		return reflect.ValueOf(&err.Error).Elem(), nil
+	}
+
+	switch t {
+	case reflect.TypeOf((*big.Int)(nil)):
+		i := toBigInt(node)
+		if i == nil {
+			return reflect.Value{}, typeError("Integer", node)
+		}
+
+		return reflect.ValueOf(new(big.Int).Set(i)), nil
+	case reflect.TypeOf((*big.Rat)(nil)):
+		r := toRat(node)
+		if r == nil {
+			return reflect.Value{}, typeError("Integer or Rational", node)
+		}
+
+		return reflect.ValueOf(new(big.Rat).Set(r)), nil
+	}
+
+	ret := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := node.(BoolNode)
+		if !ok {
+			return reflect.Value{}, typeError("Boolean", node)
+		}
+
+		ret.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := toBigInt(node)
+		if i == nil {
+			return reflect.Value{}, typeError("Integer", node)
+		}
+
+		if !i.IsInt64() || ret.OverflowInt(i.Int64()) {
+			return reflect.Value{}, DomainError{fmt.Sprintf("%v does not fit in %v", i, t)}
+		}
+
+		ret.SetInt(i.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i := toBigInt(node)
+		if i == nil {
+			return reflect.Value{}, typeError("Integer", node)
+		}
+
+		if !i.IsUint64() || ret.OverflowUint(i.Uint64()) {
+			return reflect.Value{}, DomainError{fmt.Sprintf("%v does not fit in %v", i, t)}
+		}
+
+		ret.SetUint(i.Uint64())
+	case reflect.Float32, reflect.Float64:
+		if !isNumber(node) {
+			return reflect.Value{}, typeError("Number", node)
+		}
+
+		f := toFloat(node)
+		if t.Kind() == reflect.Float32 && math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return reflect.Value{}, DomainError{fmt.Sprintf("%v does not fit in %v", node, t)}
+		}
+
+		ret.SetFloat(f)
+	case reflect.String:
+		s, ok := node.(StringNode)
+		if !ok {
+			return reflect.Value{}, typeError("String", node)
+		}
+
+		ret.SetString(string(s))
+	case reflect.Slice:
+		expr, ok := node.(ExpressionNode)
+		if !ok || expr.Type != QExpression {
+			return reflect.Value{}, typeError("Q-Expression", node)
+		}
+
+		ret = reflect.MakeSlice(t, len(expr.Nodes), len(expr.Nodes))
+		for i, n := range expr.Nodes {
+			elem, err := FromNode(env, n, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			ret.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		m, ok := node.(MapNode)
+		if !ok {
+			return reflect.Value{}, typeError("Map", node)
+		}
+
+		ret = reflect.MakeMapWithSize(t, m.Len())
+		for _, entry := range m.entries {
+			key, err := FromNode(env, entry.Key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			value, err := FromNode(env, entry.Value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			ret.SetMapIndex(key, value)
+		}
+	case reflect.Func:
+		fun, ok := node.(FunctionNode)
+		if !ok {
+			return reflect.Value{}, typeError("Function", node)
+		}
+
+		return unwrapFunc(env, fun, t)
+	default:
+		return reflect.Value{}, UnsupportedType{t}
+	}
+
+	return ret, nil
+}
+
+// natural converts node to the Go value that suits it best, for functions
+// that accept interface{}.
+func natural(node Node) interface{} {
+	switch v := node.(type) {
+	case BoolNode:
+		return bool(v)
+	case IntNode:
+		return int64(v)
+	case BigIntNode:
+		return new(big.Int).Set(v.Value)
+	case RatNode:
+		return new(big.Rat).Set(v.Value)
+	case FloatNode:
+		return float64(v)
+	case StringNode:
+		return string(v)
+	case ErrorNode:
+		return v.Error
+	case ExpressionNode:
+		if v.Type != QExpression {
+			return node
+		}
+
+		ret := make([]interface{}, len(v.Nodes))
+		for i, n := range v.Nodes {
+			ret[i] = natural(n)
+		}
+
+		return ret
+	case MapNode:
+		ret := make(map[interface{}]interface{}, v.Len())
+		for _, entry := range v.entries {
+			ret[natural(entry.Key)] = natural(entry.Value)
+		}
+
+		return ret
+	default:
+		return node
+	}
+}
+
+// wrapFunc turns a Go function into a builtin. Its arguments are converted
+// with FromNode and its results with ToNode. A function may take the
+// environment it is called in as its first parameter, and return an error as
+// its last result. It must take some other parameter, as calling a builtin
+// without arguments only evaluates to the builtin itself.
+func wrapFunc(fn reflect.Value) (Builtin, error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, UnsupportedType{t}
+	}
+
+	withEnv := t.NumIn() > 0 && t.In(0) == envType
+
+	results := t.NumOut()
+	withError := results > 0 && t.Out(results-1) == errorType
+	if withError {
+		results--
+	}
+
+	if results > 1 {
+		return nil, fmt.Errorf("%v returns more than one value besides an error", t)
+	}
+
+	params := t.NumIn()
+	if withEnv {
+		params--
+	}
+
+	if params == 0 {
+		return nil, fmt.Errorf("%v takes no arguments, so it can't be called", t)
+	}
+
+	return func(env *Environment, args []Node) Node {
+		min, max := params, params
+		if t.IsVariadic() {
+			min, max = params-1, variadic
+		}
+
+		if err := checkArity(args, min, max); err != nil {
+			return ErrorNode{err}
+		}
+
+		in := make([]reflect.Value, 0, len(args)+1)
+		if withEnv {
+			in = append(in, reflect.ValueOf(env))
+		}
+
+		for i, arg := range args {
+			var param reflect.Type
+			if t.IsVariadic() && i >= params-1 {
+				param = t.In(t.NumIn() - 1).Elem()
+			} else {
+				param = t.In(len(in))
+			}
+
+			value, err := FromNode(env, arg, param)
+			if err != nil {
+				return ErrorNode{fmt.Errorf("argument %v: %w", i+1, err)}
+			}
+
+			in = append(in, value)
+		}
+
+		out := fn.Call(in)
+
+		if withError && !out[len(out)-1].IsNil() {
+			return ErrorNode{out[len(out)-1].Interface().(error)}
+		}
+
+		if results == 0 {
+			return ExpressionNode{Type: SExpression}
+		}
+
+		node, err := ToNode(out[0].Interface())
+		if err != nil {
+			return ErrorNode{err}
+		}
+
+		return node
+	}, nil
+}
+
+// unwrapFunc turns fun into a Go function of type t, the reverse of wrapFunc.
+// If fun fails and t has no error result to report it with, the Go function
+// panics.
+func unwrapFunc(env *Environment, fun FunctionNode, t reflect.Type) (reflect.Value, error) {
+	results := t.NumOut()
+	withError := results > 0 && t.Out(results-1) == errorType
+	if withError {
+		results--
+	}
+
+	if results > 1 {
+		return reflect.Value{}, fmt.Errorf("%v returns more than one value besides an error", t)
+	}
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+
+		fail := func(err error) []reflect.Value {
+			if !withError {
+				panic(err)
+			}
+
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+
+		args := make([]Node, 0, len(in))
+		for i, value := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < value.Len(); j++ {
+					arg, err := ToNode(value.Index(j).Interface())
+					if err != nil {
+						return fail(err)
+					}
+
+					args = append(args, arg)
+				}
+
+				break
+			}
+
+			arg, err := ToNode(value.Interface())
+			if err != nil {
+				return fail(err)
+			}
+
+			args = append(args, arg)
+		}
+
+		ret := fun.call(env, Position{}, args)
+
+		err, ok := ret.(ErrorNode)
+		if ok {
+			return fail(err.Error)
+		}
+
+		if results == 1 {
+			value, err := FromNode(env, ret, t.Out(0))
+			if err != nil {
+				return fail(err)
+			}
+
+			out[0] = value
+		}
+
+		return out
+	}), nil
+}
@@ -0,0 +1,336 @@
+package lisp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// eval evaluates input in a new environment with the standard library and
+// returns its value.
+func eval(t *testing.T, input string) (*Environment, Node) {
+	t.Helper()
+
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := EvaluateInput(&env, input)
+	if err != nil {
+		t.Fatalf("%v: %v", input, err)
+	}
+
+	return &env, node
+}
+
+func TestToNode(t *testing.T) {
+	five := 5
+	var nilInt *int
+	var nilSlice []int
+	var nilMap map[string]int
+
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "()"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{int64(math.MinInt64), "-9223372036854775808"},
+		{uint8(200), "200"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{float32(1.5), "1.5"},
+		{"s", `"s"`},
+		{big.NewInt(7), "7"},
+		{big.NewRat(2, 4), "1/2"},
+		{big.NewRat(4, 2), "2"},
+		{[]int{1, 2}, "{1 2}"},
+		{[2]string{"a", "b"}, `{"a" "b"}`},
+		{[][]int{{1}, {}, {2, 3}}, "{{1} {} {2 3}}"},
+		{[]interface{}{1, "a", nil}, `{1 "a" ()}`},
+		{nilSlice, "{}"},
+		{map[string]int{"a": 1, "b": 2}, `#{"a" 1 "b" 2}`},
+		{map[int][]bool{1: {true}}, "#{1 {true}}"},
+		{nilMap, "#{}"},
+		{&five, "5"},
+		{nilInt, "()"},
+		{errors.New("failed"), "runtime error: failed"},
+		{IdentifierNode("x"), "x"},
+	}
+
+	for _, test := range tests {
+		got, err := ToNode(test.value)
+		if err != nil {
+			t.Errorf("ToNode(%#v): %v", test.value, err)
+		} else if got.String() != test.want {
+			t.Errorf("ToNode(%#v) = %v, want %v", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []interface{}{make(chan int), complex(1, 2), []chan int{nil}, map[string]chan int{"a": nil}, map[[1]int]int{{1}: 1}, func() {}} {
+		_, err := ToNode(value)
+		if err == nil {
+			t.Errorf("ToNode(%#v) succeeded", value)
+		}
+	}
+}
+
+func TestFromNode(t *testing.T) {
+	tests := []struct {
+		input string
+		t     reflect.Type
+		want  interface{}
+		// err is the type of error expected, if any.
+		err interface{}
+	}{
+		{`true`, reflect.TypeOf(false), true, nil},
+		{`127`, reflect.TypeOf(int8(0)), int8(127), nil},
+		{`128`, reflect.TypeOf(int8(0)), nil, DomainError{}},
+		{`-129`, reflect.TypeOf(int8(0)), nil, DomainError{}},
+		{`9223372036854775807`, reflect.TypeOf(0), math.MaxInt64, nil},
+		{`9223372036854775808`, reflect.TypeOf(int64(0)), nil, DomainError{}},
+		{`18446744073709551615`, reflect.TypeOf(uint64(0)), uint64(math.MaxUint64), nil},
+		{`18446744073709551616`, reflect.TypeOf(uint64(0)), nil, DomainError{}},
+		{`-1`, reflect.TypeOf(uint(0)), nil, DomainError{}},
+		{`256`, reflect.TypeOf(uint8(0)), nil, DomainError{}},
+		{`1.5`, reflect.TypeOf(0), nil, TypeError{}},
+		{`(/ 1 2)`, reflect.TypeOf(0), nil, TypeError{}},
+		{`3`, reflect.TypeOf(0.0), 3.0, nil},
+		{`(/ 1 4)`, reflect.TypeOf(0.0), 0.25, nil},
+		{"1" + strings.Repeat("0", 300) + ".0", reflect.TypeOf(float32(0)), nil, DomainError{}},
+		{`"a"`, reflect.TypeOf(0.0), nil, TypeError{}},
+		{`"héllo"`, reflect.TypeOf(""), "héllo", nil},
+		{`{1 2}`, reflect.TypeOf([]int{}), []int{1, 2}, nil},
+		{`{}`, reflect.TypeOf([]int{}), []int{}, nil},
+		{`{{1} {} {2 3}}`, reflect.TypeOf([][]int{}), [][]int{{1}, {}, {2, 3}}, nil},
+		{`{1 "a"}`, reflect.TypeOf([]int{}), nil, TypeError{}},
+		{`{1 300}`, reflect.TypeOf([]int8{}), nil, DomainError{}},
+		{`#{"a" {1} "b" {}}`, reflect.TypeOf(map[string][]int{}), map[string][]int{"a": {1}, "b": {}}, nil},
+		{`#{1 "a"}`, reflect.TypeOf(map[string]string{}), nil, TypeError{}},
+		{`{1}`, reflect.TypeOf(map[string]string{}), nil, TypeError{}},
+		{`9223372036854775808`, reflect.TypeOf((*big.Int)(nil)), new(big.Int).Lsh(big.NewInt(1), 63), nil},
+		{`(/ 1 3)`, reflect.TypeOf((*big.Rat)(nil)), big.NewRat(1, 3), nil},
+		{`5`, reflect.TypeOf((*Node)(nil)).Elem(), IntNode(5), nil},
+		{`5`, reflect.TypeOf(IntNode(0)), IntNode(5), nil},
+		{`5`, reflect.TypeOf(make(chan int)), nil, UnsupportedType{}},
+	}
+
+	for _, test := range tests {
+		env, node := eval(t, test.input)
+
+		got, err := FromNode(env, node, test.t)
+		if test.err != nil {
+			target := reflect.New(reflect.TypeOf(test.err))
+			if !errors.As(err, target.Interface()) {
+				t.Errorf("FromNode(%v, %v): got %v, want %T", test.input, test.t, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("FromNode(%v, %v): %v", test.input, test.t, err)
+		} else if !reflect.DeepEqual(got.Interface(), test.want) {
+			t.Errorf("FromNode(%v, %v) = %#v, want %#v", test.input, test.t, got.Interface(), test.want)
+		}
+	}
+}
+
+func TestNatural(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`true`, "bool true"},
+		{`5`, "int64 5"},
+		{`9223372036854775808`, "*big.Int 9223372036854775808"},
+		{`(/ 1 2)`, "*big.Rat 1/2"},
+		{`1.5`, "float64 1.5"},
+		{`"a"`, "string a"},
+		{`{1 "a" {}}`, "[]interface {} [1 a []]"},
+		{`#{"a" {1.5}}`, "map[interface {}]interface {} map[a:[1.5]]"},
+		{`{{a}}`, "[]interface {} [[a]]"},
+		{`(ref 1)`, "lisp.RefNode"},
+	}
+
+	for _, test := range tests {
+		_, node := eval(t, test.input)
+
+		got := natural(node)
+		if s := fmt.Sprintf("%T %v", got, got); !strings.HasPrefix(s, test.want) {
+			t.Errorf("natural(%v) = %v, want %v", test.input, s, test.want)
+		}
+	}
+
+	if _, ok := natural(IdentifierNode("a")).(IdentifierNode); !ok {
+		t.Errorf("natural(a) isn't an IdentifierNode")
+	}
+}
+
+func TestWrapFunc(t *testing.T) {
+	funcs := map[string]interface{}{
+		"add":    func(a, b int) int { return a + b },
+		"small":  func(n int8) int8 { return n },
+		"unsign": func(n uint) uint { return n },
+		"sum": func(sep string, xs ...int) string {
+			parts := make([]string, len(xs))
+			for i, x := range xs {
+				parts[i] = fmt.Sprint(x)
+			}
+
+			return strings.Join(parts, sep)
+		},
+		"sqrt": func(n float64) (float64, error) {
+			if n < 0 {
+				return 0, DomainError{"negative"}
+			}
+
+			return math.Sqrt(n), nil
+		},
+		"check": func(n int) error {
+			if n < 0 {
+				return errors.New("negative")
+			}
+
+			return nil
+		},
+		"depth":   func(env *Environment, n int) int { return env.state.maxDepth + n },
+		"nothing": func(s string) {},
+		"none":    func(n int) []int { return nil },
+		"nilptr":  func(n int) *int { return nil },
+		"grid":    func(rows [][]int) map[string]int { return map[string]int{"rows": len(rows)} },
+		"any":     func(v interface{}) string { return fmt.Sprintf("%T", v) },
+	}
+
+	in, err := NewInterpreterWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, fn := range funcs {
+		if err := in.Register(name, fn); err != nil {
+			t.Fatalf("Register(%v): %v", name, err)
+		}
+	}
+
+	tests := []evalTest{
+		{`add 1 2`, "3"},
+		{`try {add 1 2 3} {e} {error-type e}`, `"arity"`},
+		{`try {add 1} {e} {error-type e}`, `"arity"`},
+		{`try {add 1 "a"} {e} {error-message e}`, `"argument 2: expected Integer, got String"`},
+		{`small -128`, "-128"},
+		{`try {small 128} {e} {error-message e}`, `"argument 1: 128 does not fit in int8"`},
+		{`try {small 128} {e} {error-type e}`, `"domain"`},
+		{`try {unsign -1} {e} {error-type e}`, `"domain"`},
+		{`unsign 18446744073709551615`, "18446744073709551615"},
+		{`sum ","`, `""`},
+		{`sum "," 1 2 3`, `"1,2,3"`},
+		{`try {sum "," 1 "a"} {e} {error-message e}`, `"argument 3: expected Integer, got String"`},
+		{`sqrt 4`, "2.0"},
+		{`try {sqrt -4} {e} {error-type e}`, `"domain"`},
+		{`check 1`, "()"},
+		{`try {check -1} {e} {error-message e}`, `"negative"`},
+		{`depth 1`, fmt.Sprint(DefaultMaxDepth + 1)},
+		{`nothing "a"`, "()"},
+		{`none 1`, "{}"},
+		{`nilptr 1`, "()"},
+		{`grid {{1} {2 3}}`, `#{"rows" 2}`},
+		{`any {1}`, `"[]interface {}"`},
+		{`any 9223372036854775808`, `"*big.Int"`},
+	}
+
+	for _, test := range tests {
+		got, err := in.Eval(test.input)
+		if err != nil {
+			t.Errorf("%v: %v", test.input, err)
+		} else if got.String() != test.want {
+			t.Errorf("%v = %v, want %v", test.input, got, test.want)
+		}
+	}
+
+	for _, fn := range []interface{}{
+		func() int { return 1 },
+		func(env *Environment) int { return 1 },
+		func(n int) (int, int) { return n, n },
+		func(n int) (int, int, error) { return n, n, nil },
+		5,
+	} {
+		if err := in.Register("bad", fn); err == nil {
+			t.Errorf("Register(%T) succeeded", fn)
+		}
+	}
+}
+
+func TestUnwrapFunc(t *testing.T) {
+	in, err := NewInterpreterWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = in.Eval(`(fun {add a b} {+ a b}) (fun {count & xs} {len xs}) (fun {fail x} {raise "bad"}) (fun {name x} {"a"}) (fun {rows xs} {len (head xs)})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var add func(int, int) int
+	if err := in.GetAs("add", &add); err != nil {
+		t.Fatal(err)
+	} else if got := add(1, 2); got != 3 {
+		t.Errorf("add(1, 2) = %v", got)
+	}
+
+	var count func(...int) int
+	if err := in.GetAs("count", &count); err != nil {
+		t.Fatal(err)
+	} else if got := count(1, 2, 3); got != 3 {
+		t.Errorf("count(1, 2, 3) = %v", got)
+	}
+
+	var rows func([][]int) int
+	if err := in.GetAs("rows", &rows); err != nil {
+		t.Fatal(err)
+	} else if got := rows([][]int{{1, 2}, {3}}); got != 1 {
+		t.Errorf("rows = %v", got)
+	}
+
+	var fail func(int) (int, error)
+	if err := in.GetAs("fail", &fail); err != nil {
+		t.Fatal(err)
+	} else if _, err := fail(1); err == nil || !strings.HasPrefix(err.Error(), "bad") {
+		t.Errorf("fail(1) returned %v", err)
+	}
+
+	// Results that don't convert are reported like errors.
+	var name func(int) (int, error)
+	if err := in.GetAs("name", &name); err != nil {
+		t.Fatal(err)
+	} else if _, err := name(1); !errors.As(err, new(TypeError)) {
+		t.Errorf("name(1) returned %v, want TypeError", err)
+	}
+
+	// Without an error result, failing panics.
+	var panics func(int) int
+	if err := in.GetAs("fail", &panics); err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("fail(1) didn't panic")
+			}
+		}()
+
+		panics(1)
+	}()
+
+	var pair func(int) (int, int)
+	if err := in.GetAs("add", &pair); err == nil {
+		t.Errorf("converting to %T succeeded", pair)
+	}
+}
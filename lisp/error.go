@@ -0,0 +1,259 @@
+package lisp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrorValueNode is an error as a value, which code can inspect and raise.
+// Errors caught by try are turned into one.
+type ErrorValueNode struct {
+	Type    string
+	Message string
+	Payload Node
+	// cause is the error that was caught, raised again instead of the value
+	// so its trace and type are kept.
+	cause error
+}
+
+func (_ ErrorValueNode) TypeString() string {
+	return "Error"
+}
+
+func (e ErrorValueNode) String() string {
+	return printNode(e)
+}
+
+func (e ErrorValueNode) Error() string {
+	return e.Message
+}
+
+func (e ErrorValueNode) Evaluate(_ *Environment) Node {
+	return e
+}
+
+// classify returns the type of an error raised by the interpreter, as
+// reported by error-type.
+func classify(err error) string {
+	var (
+		value    ErrorValueNode
+		depth    MaxDepthExceeded
+		type_    IncorrectType
+		arity    ArityError
+		domain   DomainError
+		notFound ImportNotFound
+		cycle    ImportCycle
+		export   NotExported
+		char     UnexpectedCharacter
+		token    UnexpectedToken
+		eoi      UnexpectedEOI
+		literal  InvalidLiteral
+		path     *os.PathError
+		panic_   PanicError
+		time_    TimeLimitExceeded
+		steps    StepLimitExceeded
+		length   LengthLimitExceeded
+		integer  IntegerLimitExceeded
+		source   SourceLimitExceeded
+		allowed  ImportNotAllowed
+	)
+
+	switch {
+	case errors.As(err, &value):
+		return value.Type
+	case errors.As(err, &depth):
+		return "recursion"
+	case errors.As(err, &type_):
+		return "type"
+	case errors.As(err, &arity):
+		return "arity"
+	case errors.As(err, &domain):
+		return "domain"
+	case errors.As(err, &time_), errors.As(err, &steps), errors.As(err, &length),
+		errors.As(err, &integer), errors.As(err, &source):
+		return "limit"
+	case errors.As(err, &notFound), errors.As(err, &cycle), errors.As(err, &export), errors.As(err, &allowed):
+		return "import"
+	case errors.As(err, &char), errors.As(err, &token), errors.As(err, &eoi), errors.As(err, &literal):
+		return "syntax"
+	case errors.As(err, &path):
+		return "io"
+	case errors.As(err, &panic_):
+		return "panic"
+	default:
+		return "error"
+	}
+}
+
+// newErrorValue turns an error that was raised into a value.
+func newErrorValue(err error) ErrorValueNode {
+	var value ErrorValueNode
+	if errors.As(err, &value) {
+		return value
+	}
+
+	message := err
+	for {
+		runtime, ok := message.(*RuntimeError)
+		if !ok {
+			break
+		}
+
+		message = runtime.Err
+	}
+
+	return ErrorValueNode{Type: classify(err), Message: message.Error(), cause: err}
+}
+
+func errorValueArg(node Node) (ErrorValueNode, error) {
+	e, ok := node.(ErrorValueNode)
+	if !ok {
+		return ErrorValueNode{}, typeError("Error", node)
+	}
+
+	return e, nil
+}
+
+// ErrorBuiltin creates an error with a message and optionally a payload of any
+// type, without raising it.
+func ErrorBuiltin(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	message, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	ret := ErrorValueNode{Type: "error", Message: string(message)}
+	if len(args) == 2 {
+		ret.Payload = args[1]
+	}
+
+	return ret
+}
+
+// Raise raises an error created by error or caught by try. Given a string, it
+// raises a new error with that message.
+func Raise(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	switch v := args[0].(type) {
+	case StringNode:
+		return ErrorNode{ErrorValueNode{Type: "error", Message: string(v)}}
+	case ErrorValueNode:
+		if v.cause != nil {
+			return ErrorNode{v.cause}
+		}
+
+		return ErrorNode{v}
+	default:
+		return ErrorNode{typeError("Error or String", args[0])}
+	}
+}
+
+// Try evaluates its first argument. If that raises an error, the handler is
+// evaluated with the error bound to the name given before it:
+//
+//	try {read-file "x"} {e} {error-message e}
+//
+// An optional last argument is evaluated afterwards whether an error was
+// raised or not, and its value is ignored.
+func Try(env *Environment, args []Node) Node {
+	if err := checkArity(args, 3, 4); err != nil {
+		return ErrorNode{err}
+	}
+
+	for _, arg := range args {
+		expr, ok := arg.(ExpressionNode)
+		if !ok || expr.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression", arg)}
+		}
+	}
+
+	formals := args[1].(ExpressionNode).Nodes
+	if len(formals) != 1 {
+		return ErrorNode{DomainError{fmt.Sprintf("expected 1 name for the error, got %v", len(formals))}}
+	}
+
+	name, ok := formals[0].(IdentifierNode)
+	if !ok {
+		return ErrorNode{typeError("Identifier", formals[0])}
+	}
+
+	ret := args[0].(ExpressionNode).EvalAsSExpr(env)
+
+	err, failed := ret.(ErrorNode)
+	if failed {
+		handlerEnv := NewEnvironment(env)
+		handlerEnv.Put(name, newErrorValue(err.Error))
+
+		handler := tailCall{args[2].(ExpressionNode), &handlerEnv}
+		if len(args) == 3 {
+			return handler
+		}
+
+		ret = handler.Evaluate(env)
+	}
+
+	if len(args) == 4 {
+		cleanup := args[3].(ExpressionNode).EvalAsSExpr(env)
+		if _, ok := cleanup.(ErrorNode); ok {
+			return cleanup
+		}
+	}
+
+	return ret
+}
+
+func ErrorMessage(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	e, err := errorValueArg(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	return StringNode(e.Message)
+}
+
+// ErrorType returns what kind of error an error is, like "type", "arity" or
+// "io".
+// Errors created with error have the type "error".
+func ErrorType(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	e, err := errorValueArg(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	return StringNode(e.Type)
+}
+
+// ErrorPayload returns the payload an error was created with, or () if it has
+// none.
+func ErrorPayload(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	e, err := errorValueArg(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	if e.Payload == nil {
+		return ExpressionNode{Type: SExpression}
+	}
+
+	return e.Payload
+}
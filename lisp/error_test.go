@@ -0,0 +1,152 @@
+package lisp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRaisedErrorsKeepTheirTrace(t *testing.T) {
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = EvaluateInput(&env, `(try {head {}} {e} {def {saved} e}) (fun {f n} {raise saved})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range []string{"try {f 1} {e} {()}", "try {f 2} {e} {()}", "try {f 3} {e} {()}"} {
+		_, err := EvaluateInput(&env, input)
+		if err != nil {
+			t.Fatalf("%v: %v", input, err)
+		}
+	}
+
+	_, err = EvaluateInput(&env, "f 4")
+	if err == nil {
+		t.Fatal("f 4 succeeded")
+	}
+
+	for _, call := range []string{"(f 1)", "(f 2)", "(f 3)"} {
+		if strings.Contains(err.Error(), call) {
+			t.Errorf("trace of f 4 contains %v:\n%v", call, err)
+		}
+	}
+
+	if !strings.Contains(err.Error(), "(f 4)") || !strings.Contains(err.Error(), "(head {})") {
+		t.Errorf("trace of f 4 is missing calls:\n%v", err)
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	tests := map[string]string{
+		`head {1} {2}`:        "arity",
+		`head 5`:              "type",
+		`/ 1 0`:               "domain",
+		`/ 1.0 0`:             "domain",
+		`% 1.5 0`:             "domain",
+		`sort {1 "a"}`:        "type",
+		`assoc #{} 1`:         "arity",
+		`assoc #{} 1 2 3`:     "domain",
+		`format "%"`:          "domain",
+		`format "%d"`:         "domain",
+		`format "x" 1`:        "domain",
+		`format "%z" 1`:       "domain",
+		`try {1} {a b} {2}`:   "domain",
+		`set {undefined} 1`:   "domain",
+		`set {undefined} 1 2`: "arity",
+	}
+
+	for input, want := range tests {
+		got := run(t, "try {"+input+"} {e} {error-type e}")
+		if got != `"`+want+`"` {
+			t.Errorf("error type of %v = %v, want %q", input, got, want)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := map[string]Position{
+		`+ 1 )`:     {Line: 1, Column: 5},
+		`list (1 2`: {Line: 1, Column: 6},
+		`str "a\q"`: {Line: 1, Column: 5},
+		`+ 1` + strings.Repeat("0", 400) + `.5 1`: {Line: 1, Column: 3},
+	}
+
+	for input, want := range tests {
+		_, err := ParseSource("", input, SExpression)
+		if err == nil {
+			t.Errorf("%v parsed without an error", input)
+			continue
+		}
+
+		got, ok := ErrorPosition(err)
+		if !ok || got.Line != want.Line || got.Column != want.Column {
+			t.Errorf("position of %v = %v, %v, want %v", input, got, ok, want)
+		}
+	}
+}
+
+func TestPanics(t *testing.T) {
+	in, err := NewInterpreterWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	err = in.Register("boom", func(n int64) int64 {
+		if n == 0 {
+			panic(failure)
+		}
+
+		var m map[string]int
+		m["x"] = int(n)
+		return n
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for input, want := range map[string]string{
+		`try {boom 1} {e} {error-type e}`:                                              `"panic"`,
+		`try {boom 0} {e} {error-message e}`:                                           `"panic: failure"`,
+		`(fun {f n} {if (= n 0) {boom 0} {f (- n 1)}}) (try {f 5} {e} {error-type e})`: `"panic"`,
+		`try {map boom {1 2}} {e} {error-type e}`:                                      `"panic"`,
+		`+ 1 2`: "3",
+	} {
+		out, err := in.Eval(input)
+		if err != nil {
+			t.Errorf("%v: %v", input, err)
+		} else if out.String() != want {
+			t.Errorf("%v = %v, want %v", input, out, want)
+		}
+	}
+
+	_, err = in.Eval(`(fun {g n} {* 2 (boom n)}) (g 0)`)
+
+	var p PanicError
+	if !errors.As(err, &p) || len(p.Stack) == 0 {
+		t.Fatalf("got %v, want PanicError with a stack", err)
+	}
+
+	if !errors.Is(err, failure) {
+		t.Errorf("%v doesn't wrap the value of the panic", err)
+	}
+
+	if !strings.Contains(err.Error(), "(boom 0)") {
+		t.Errorf("trace is missing the call that panicked:\n%v", err)
+	}
+
+	_, err = in.Call("boom", 1)
+	if !errors.As(err, &p) {
+		t.Errorf("Call: got %v, want PanicError", err)
+	}
+
+	// Panics don't leave the recursion depth behind.
+	out, err := in.Eval(`(fun {down n} {if (= n 0) {0} {+ 1 (down (- n 1))}}) (down 9000)`)
+	if err != nil || out.String() != "9000" {
+		t.Errorf("down 9000 = %v, %v after panics", out, err)
+	}
+}
@@ -1,17 +1,115 @@
 package lisp
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sort"
 )
 
+// DefaultMaxDepth is the maximum call depth of a new environment.
+const DefaultMaxDepth = 10000
+
+// MaxDepthExceeded is returned when evaluation nests deeper than the maximum
+// call depth of an environment.
+type MaxDepthExceeded struct {
+	Depth int
+}
+
+func (e MaxDepthExceeded) Error() string {
+	return fmt.Sprintf("maximum recursion depth exceeded (%v)", e.Depth)
+}
+
+// PanicError is returned when Go code panics during evaluation, usually a bug
+// in a builtin. Stack is the Go stack at the time of the panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value of the panic if it is an error.
+func (e PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// recoverError turns a panic into a PanicError stored in err. It must be
+// deferred directly.
+func recoverError(err *error) {
+	value := recover()
+	if value != nil {
+		*err = PanicError{value, debug.Stack()}
+	}
+}
+
+// state is shared by an environment and all environments created from it, so
+// setting the maximum depth, output or input of one sets them for all.
+type state struct {
+	depth    int
+	maxDepth int
+	output   io.Writer
+	input    *bufio.Reader
+	// files is the stack of source files being evaluated, innermost last.
+	files []string
+	// modules holds the modules loaded by require, by absolute path.
+	modules map[string]*Environment
+	// stdlib is set once the standard library is loaded, so modules get it
+	// too.
+	stdlib bool
+	// limits is only set for sandboxed environments.
+	limits *limits
+}
+
 type Environment struct {
 	Parent *Environment
 	values map[IdentifierNode]Node
+	state  *state
+	// module is only set on the root environment of a module, or of the
+	// program itself.
+	module *module
+	// call is set on the environment a function call binds its arguments in.
+	call bool
 }
 
 func NewEnvironment(parent *Environment) Environment {
-	return Environment{parent, make(map[IdentifierNode]Node)}
+	env := Environment{Parent: parent, values: make(map[IdentifierNode]Node)}
+
+	if parent != nil {
+		env.state = parent.state
+	} else {
+		env.state = &state{maxDepth: DefaultMaxDepth, output: os.Stdout, modules: make(map[string]*Environment)}
+		env.module = newModule("")
+	}
+
+	return env
+}
+
+// SetMaxDepth sets how deep evaluation may nest before it fails with
+// MaxDepthExceeded.
+func (env *Environment) SetMaxDepth(depth int) {
+	env.state.maxDepth = depth
+}
+
+// enter counts a level of nesting against the maximum depth, for Go code that
+// recurses into nested values. leave must be called once it returns.
+func (s *state) enter() error {
+	if s.depth >= s.maxDepth {
+		return MaxDepthExceeded{s.maxDepth}
+	}
+
+	s.depth++
+	return nil
+}
+
+func (s *state) leave() {
+	s.depth--
 }
 
 func (env *Environment) Get(id IdentifierNode) Node {
@@ -27,81 +125,307 @@ func (env *Environment) Get(id IdentifierNode) Node {
 	return nil
 }
 
+// Names returns every identifier visible from env, sorted.
+func (env *Environment) Names() []IdentifierNode {
+	seen := make(map[IdentifierNode]bool)
+	names := make([]IdentifierNode, 0)
+
+	for e := env; e != nil; e = e.Parent {
+		for id := range e.values {
+			if !seen[id] {
+				seen[id] = true
+				names = append(names, id)
+			}
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
 func (env *Environment) Put(id IdentifierNode, value Node) {
 	env.values[id] = value
+
+	if env.module != nil {
+		delete(env.module.prelude, id)
+	}
 }
 
+// Set changes the value of id in the closest environment that binds it. It
+// returns false, and changes nothing, if id isn't bound.
+func (env *Environment) Set(id IdentifierNode, value Node) bool {
+	for e := env; e != nil; e = e.Parent {
+		_, ok := e.values[id]
+		if ok {
+			e.Put(id, value)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Def binds id in the root environment of the module env belongs to.
 func (env *Environment) Def(id IdentifierNode, value Node) {
-	if env.Parent != nil {
-		env.Parent.Def(id, value)
-		return
+	env.moduleRoot().Put(id, value)
+}
+
+// scope returns the environment def binds in, which is the environment of the
+// function call env belongs to, or the root environment of the module outside
+// of functions.
+func (env *Environment) scope() *Environment {
+	for !env.call && env.module == nil && env.Parent != nil {
+		env = env.Parent
 	}
 
-	env.Put(id, value)
+	return env
+}
+
+func (env *Environment) addBuiltin(name string, builtin Builtin) {
+	env.Def(IdentifierNode(name), FunctionNode{Name: name, Builtin: builtin})
+}
+
+// addMacro adds a builtin that receives its arguments unevaluated, like a
+// macro.
+func (env *Environment) addMacro(name string, builtin Builtin) {
+	env.Def(IdentifierNode(name), FunctionNode{Name: name, Builtin: builtin, Macro: true})
 }
 
 func (env *Environment) AddBuiltins() {
-	env.Def("+", FunctionNode{Builtin: Add})
-	env.Def("-", FunctionNode{Builtin: Sub})
-	env.Def("*", FunctionNode{Builtin: Mul})
-	env.Def("/", FunctionNode{Builtin: Div})
-
-	env.Def("=", FunctionNode{Builtin: Equal})
-	env.Def("<", FunctionNode{Builtin: Less})
-	env.Def("<=", FunctionNode{Builtin: LessEqual})
-	env.Def(">", FunctionNode{Builtin: More})
-	env.Def(">=", FunctionNode{Builtin: MoreEqual})
-	env.Def("%", FunctionNode{Builtin: Mod})
-
-	env.Def("import", FunctionNode{Builtin: Import})
-	env.Def("head", FunctionNode{Builtin: Head})
-	env.Def("tail", FunctionNode{Builtin: Tail})
-	env.Def("post", FunctionNode{Builtin: Post})
-	env.Def("init", FunctionNode{Builtin: Init})
-	env.Def("list", FunctionNode{Builtin: List})
-	env.Def("eval", FunctionNode{Builtin: Eval})
-	env.Def("join", FunctionNode{Builtin: Join})
-	env.Def("def", FunctionNode{Builtin: Def})
-	env.Def("let", FunctionNode{Builtin: Let})
-	env.Def("fn", FunctionNode{Builtin: Fn})
-	env.Def("if", FunctionNode{Builtin: If})
+	env.addBuiltin("+", Add)
+	env.addBuiltin("-", Sub)
+	env.addBuiltin("*", Mul)
+	env.addBuiltin("/", Div)
+
+	env.addBuiltin("=", Equal)
+	env.addBuiltin("<", Less)
+	env.addBuiltin("<=", LessEqual)
+	env.addBuiltin(">", More)
+	env.addBuiltin(">=", MoreEqual)
+	env.addBuiltin("%", Mod)
+
+	env.addBuiltin("import", Import)
+	env.addBuiltin("require", Require)
+	env.addBuiltin("export", Export)
+	env.addBuiltin("head", Head)
+	env.addBuiltin("tail", Tail)
+	env.addBuiltin("post", Post)
+	env.addBuiltin("init", Init)
+	env.addBuiltin("list", List)
+	env.addBuiltin("eval", Eval)
+	env.addBuiltin("join", Join)
+	env.addBuiltin("def", Def)
+	env.addBuiltin("let", Let)
+	env.addBuiltin("set", Set)
+	env.addBuiltin("ref", Ref)
+	env.addBuiltin("deref", Deref)
+	env.addBuiltin("swap!", Swap)
+	env.addBuiltin("fn", Fn)
+	env.addBuiltin("macro", Macro)
+	env.addMacro("do", Do)
+	env.addBuiltin("if", If)
+	env.addBuiltin("error", ErrorBuiltin)
+	env.addBuiltin("raise", Raise)
+	env.addBuiltin("try", Try)
+	env.addBuiltin("error-message", ErrorMessage)
+	env.addBuiltin("error-type", ErrorType)
+	env.addBuiltin("error-payload", ErrorPayload)
+	env.addBuiltin("bool", Bool)
+	env.addBuiltin("len", Len)
+	env.addBuiltin("first", First)
+	env.addBuiltin("last", Last)
+	env.addBuiltin("nth", Nth)
+	env.addBuiltin("take", Take)
+	env.addBuiltin("drop", Drop)
+	env.addBuiltin("dropLast", DropLast)
+	env.addBuiltin("reverse", Reverse)
+	env.addBuiltin("range", Range)
+	env.addBuiltin("map", Map)
+	env.addBuiltin("filter", Filter)
+	env.addBuiltin("foldl", Foldl)
+	env.addBuiltin("reduce", Reduce)
+	env.addBuiltin("zip", Zip)
+	env.addBuiltin("sort", Sort)
+
+	env.addBuiltin("strLen", StrLen)
+	env.addBuiltin("substr", Substr)
+	env.addBuiltin("indexOf", IndexOf)
+	env.addBuiltin("contains", Contains)
+	env.addBuiltin("startsWith", StartsWith)
+	env.addBuiltin("endsWith", EndsWith)
+	env.addBuiltin("split", Split)
+	env.addBuiltin("strJoin", StrJoin)
+	env.addBuiltin("trim", Trim)
+	env.addBuiltin("upper", Upper)
+	env.addBuiltin("lower", Lower)
+	env.addBuiltin("replace", Replace)
+	env.addBuiltin("repeat", Repeat)
+	env.addBuiltin("padLeft", PadLeft)
+	env.addBuiltin("padRight", PadRight)
+
+	env.addBuiltin("str", Str)
+	env.addBuiltin("repr", Repr)
+	env.addBuiltin("parse-number", ParseNumberBuiltin)
+	env.addBuiltin("format", Format)
+
+	env.addBuiltin("print", Print)
+	env.addBuiltin("println", Println)
+	env.addBuiltin("read-line", ReadLine)
+	env.addBuiltin("read-file", ReadFile)
+	env.addBuiltin("write-file", WriteFile)
+	env.addBuiltin("append-file", AppendFile)
+	env.addBuiltin("file-exists?", FileExists)
+	env.addBuiltin("list-dir", ListDir)
+
+	env.addBuiltin("get", Get)
+	env.addBuiltin("assoc", Assoc)
+	env.addBuiltin("dissoc", Dissoc)
+	env.addBuiltin("keys", Keys)
+	env.addBuiltin("vals", Vals)
+	env.addBuiltin("has?", Has)
+}
+
+// tailCall is returned by builtins and functions that end by evaluating an
+// expression. Instead of recursing, EvalAsSExpr continues its loop with Expr,
+// so calls in tail position run in constant Go stack.
+type tailCall struct {
+	Expr ExpressionNode
+	Env  *Environment
+}
+
+func (_ tailCall) TypeString() string {
+	return "Tail Call"
+}
+
+func (t tailCall) String() string {
+	return t.Expr.String()
+}
+
+func (t tailCall) Evaluate(_ *Environment) Node {
+	return t.Expr.EvalAsSExpr(t.Env)
 }
 
 func (e ExpressionNode) EvalAsSExpr(env *Environment) Node {
-	if len(e.Nodes) == 0 {
-		return e
+	state := env.state
+	if state.depth >= state.maxDepth {
+		return ErrorNode{MaxDepthExceeded{state.maxDepth}}
 	}
 
-	nodes := make([]Node, len(e.Nodes))
-	for i, node := range e.Nodes {
-		evaluated := node.Evaluate(env)
+	if state.depth == 0 {
+		defer env.startBudget()()
+	}
 
-		_, ok := evaluated.(ErrorNode)
-		if ok {
-			return evaluated
+	state.depth++
+	defer func() { state.depth-- }()
+
+	// The first and the most recent function entered through a tail call,
+	// kept so errors still show where they came from.
+	var entry, last *Frame
+
+	fail := func(err ErrorNode) Node {
+		if last != nil {
+			err = err.withFrame(*last)
 		}
 
-		nodes[i] = evaluated
-	}
+		if entry != last {
+			err = err.withFrame(*entry)
+		}
 
-	if len(nodes) == 1 {
-		return nodes[0]
+		return err
 	}
 
-	op := nodes[0]
-	args := nodes[1:]
+	for {
+		if err := state.step(); err != nil {
+			return fail(ErrorNode{err})
+		}
 
-	fun, ok := op.(FunctionNode)
-	if !ok {
-		return ErrorNode{errors.New("S-Expressions should start with an function")}
-	}
+		if len(e.Nodes) == 0 {
+			return e
+		}
+
+		if len(e.Nodes) == 1 {
+			expr, ok := e.Nodes[0].(ExpressionNode)
+			if ok && expr.Type == SExpression {
+				e = expr
+				continue
+			}
+		}
+
+		if err := env.checkLength(len(e.Nodes)); err != nil {
+			return fail(ErrorNode{err})
+		}
+
+		nodes := make([]Node, len(e.Nodes))
+		for i, node := range e.Nodes {
+			evaluated := node.Evaluate(env)
+
+			err, ok := evaluated.(ErrorNode)
+			if ok {
+				return fail(err)
+			}
+
+			nodes[i] = evaluated
+
+			// The arguments of a macro are passed without evaluating them.
+			fun, ok := evaluated.(FunctionNode)
+			if i == 0 && ok && fun.Macro {
+				copy(nodes[1:], e.Nodes[1:])
+				break
+			}
+		}
+
+		if len(nodes) == 1 {
+			return nodes[0]
+		}
 
-	return fun.call(env, args)
+		op := nodes[0]
+		args := nodes[1:]
+
+		fun, ok := op.(FunctionNode)
+		if !ok {
+			return fail(ErrorNode{errors.New("S-Expressions should start with an function")})
+		}
+
+		frame := Frame{fun.Name, e.Pos, args}
+
+		var ret Node
+		if fun.Macro {
+			ret = fun.expand(env, args)
+		} else {
+			ret = fun.apply(env, args)
+		}
+
+		tail, ok := ret.(tailCall)
+		if !ok {
+			err, ok := ret.(ErrorNode)
+			if ok {
+				return fail(err.withFrame(frame))
+			}
+
+			return ret
+		}
+
+		if fun.Builtin == nil {
+			if entry == nil {
+				entry = &frame
+			}
+
+			last = &frame
+		}
+
+		e, env = tail.Expr, tail.Env
+	}
 }
 
 func (e ExpressionNode) Evaluate(env *Environment) Node {
-	if e.Type == QExpression {
+	switch e.Type {
+	case QExpression:
 		return e
+	case MapExpression:
+		return evaluateMap(e, env)
 	}
 
 	return e.EvalAsSExpr(env)
@@ -116,8 +440,28 @@ func (i IdentifierNode) Evaluate(env *Environment) Node {
 	return node
 }
 
-func (v NumberNode) Evaluate(_ *Environment) Node {
-	return v
+func (i IntNode) Evaluate(_ *Environment) Node {
+	return i
+}
+
+func (i BigIntNode) Evaluate(_ *Environment) Node {
+	return i
+}
+
+func (r RatNode) Evaluate(_ *Environment) Node {
+	return r
+}
+
+func (f FloatNode) Evaluate(_ *Environment) Node {
+	return f
+}
+
+func (m MapNode) Evaluate(_ *Environment) Node {
+	return m
+}
+
+func (b BoolNode) Evaluate(_ *Environment) Node {
+	return b
 }
 
 func (s StringNode) Evaluate(_ *Environment) Node {
@@ -128,19 +472,60 @@ func (e ErrorNode) Evaluate(_ *Environment) Node {
 	return e
 }
 
-func (f FunctionNode) call(env *Environment, args []Node) Node {
+func (q QuasiquoteNode) Evaluate(env *Environment) Node {
+	return quasiquote(env, q.Node)
+}
+
+func (u UnquoteNode) Evaluate(_ *Environment) Node {
+	return ErrorNode{errors.New("cannot unquote outside of a quasiquote")}
+}
+
+// call calls f with args and evaluates the result completely, adding a frame
+// for this call to any error that comes out of it. pos is the position of the
+// call site.
+func (f FunctionNode) call(env *Environment, pos Position, args []Node) Node {
+	if f.Macro {
+		return ErrorNode{errors.New("macros can only be called directly")}
+	}
+
+	ret := f.apply(env, args)
+
+	tail, ok := ret.(tailCall)
+	if ok {
+		ret = tail.Evaluate(env)
+	}
+
+	err, ok := ret.(ErrorNode)
+	if ok {
+		return err.withFrame(Frame{f.Name, pos, args})
+	}
+
+	return ret
+}
+
+// apply calls f with args. Once every formal is bound, the body is returned
+// as a tail call for the caller to evaluate.
+func (f FunctionNode) apply(env *Environment, args []Node) Node {
 	if f.Builtin != nil {
-		return f.Builtin(env, args)
+		return callBuiltin(f.Builtin, env, args)
 	}
 
 	formals := f.Formals
 
-	funEnv := NewEnvironment(env)
+	// Functions see the environment they were created in, not the one they
+	// are called from.
+	parent := f.Environment
+	if parent == nil {
+		parent = env
+	}
+
+	funEnv := NewEnvironment(parent)
+	funEnv.call = true
 	f.Environment = &funEnv
 
 	for i, arg := range args {
 		if len(formals) == 0 {
-			return ErrorNode{fmt.Errorf("expected %v arguments, got %v", len(f.Formals), len(args))}
+			return ErrorNode{ArityError{len(f.Formals), len(f.Formals), len(args)}}
 		}
 
 		ident := formals[0]
@@ -148,12 +533,12 @@ func (f FunctionNode) call(env *Environment, args []Node) Node {
 
 		if ident == "&" {
 			if len(formals) != 1 {
-				return ErrorNode{fmt.Errorf("expected 1 variadic argument, got %v", len(formals))}
+				return ErrorNode{DomainError{fmt.Sprintf("expected 1 variadic argument, got %v", len(formals))}}
 			}
 
 			ident = formals[0]
 			formals = formals[:0]
-			f.Environment.Put(ident, ExpressionNode{QExpression, args[i:]})
+			f.Environment.Put(ident, ExpressionNode{Type: QExpression, Nodes: args[i:]})
 
 			break
 		}
@@ -162,54 +547,137 @@ func (f FunctionNode) call(env *Environment, args []Node) Node {
 	}
 
 	if len(formals) == 2 && formals[0] == "&" {
-		f.Environment.Put(formals[1], ExpressionNode{QExpression, make([]Node, 0)})
+		f.Environment.Put(formals[1], ExpressionNode{Type: QExpression, Nodes: make([]Node, 0)})
 		formals = formals[:0]
 	}
 
 	if len(formals) == 0 {
-		return f.Body.EvalAsSExpr(f.Environment)
+		return tailCall{f.Body, f.Environment}
 	}
 
 	return FunctionNode{
+		Name:        f.Name,
 		Builtin:     nil,
 		Environment: f.Environment,
 		Formals:     formals,
 		Body:        f.Body,
+		Macro:       f.Macro,
 	}
 
 }
 
+// callBuiltin calls builtin with args. If it panics, the panic is returned as
+// an error instead of unwinding through the interpreter.
+func callBuiltin(builtin Builtin, env *Environment, args []Node) (ret Node) {
+	defer func() {
+		value := recover()
+		if value != nil {
+			ret = ErrorNode{PanicError{value, debug.Stack()}}
+		}
+	}()
+
+	ret = builtin(env, args)
+	if err := env.checkResult(ret); err != nil {
+		return ErrorNode{err}
+	}
+
+	return ret
+}
+
 func (f FunctionNode) Evaluate(_ *Environment) Node {
 	return f
 }
 
 func Evaluate(env *Environment, input string, multi bool) (Node, error) {
-	tokens, err := Tokenize(input)
-	if err != nil {
-		return nil, fmt.Errorf("tokenization error: %v", err)
-	}
+	return EvaluateSource(env, "", input, multi)
+}
+
+// EvaluateSource works like Evaluate, but records file as the source of the
+// input so errors can point back into it. A panic during evaluation is
+// returned as a PanicError.
+func EvaluateSource(env *Environment, file string, input string, multi bool) (_ Node, err error) {
+	defer recoverError(&err)
+	defer env.startBudget()()
 
 	if multi {
-		expression, err := ParseExpression(tokens, QExpression)
+		_, err = evaluateAll(env, file, input, false)
 		if err != nil {
-			return nil, fmt.Errorf("parsing error: %v", err)
-		}
-
-		for _, node := range expression.Nodes {
-			out := node.Evaluate(env)
-			err, ok := out.(ErrorNode)
-			if ok {
-				return nil, err.Error
-			}
+			return nil, err
 		}
 
 		return ExpressionNode{Type: SExpression, Nodes: make([]Node, 0)}, nil
 	} else {
-		expression, err := ParseExpression(tokens, SExpression)
+		env.pushFile(file)
+		defer env.popFile()
+
+		expression, err := env.parse(file, input, SExpression)
 		if err != nil {
-			return nil, fmt.Errorf("parsing error: %v", err)
+			return nil, err
 		}
 
 		return expression.Evaluate(env), nil
 	}
 }
+
+// EvaluateInput evaluates input the way the REPL does and returns the value of
+// the last expression. Expressions are evaluated in turn, but a function
+// followed by more expressions is called with them, so both "+ 5 2" and
+// "(def {y} 1) y" work.
+func EvaluateInput(env *Environment, input string) (Node, error) {
+	return evaluateAll(env, "", input, true)
+}
+
+// evaluateAll evaluates every expression in input, which was read from file,
+// and returns the value of the last one. If calls is set, a function followed
+// by more expressions is called with them, like EvaluateInput does. A panic
+// during evaluation is returned as a PanicError.
+func evaluateAll(env *Environment, file string, input string, calls bool) (_ Node, err error) {
+	defer recoverError(&err)
+	defer env.startBudget()()
+
+	env.pushFile(file)
+	defer env.popFile()
+
+	expression, err := env.parse(file, input, QExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret Node = ExpressionNode{Type: SExpression}
+	for i, node := range expression.Nodes {
+		ret = node.Evaluate(env)
+
+		fun, ok := ret.(FunctionNode)
+		called := calls && ok && i+1 < len(expression.Nodes)
+		if called {
+			pos := expression.Pos
+			if expr, ok := node.(ExpressionNode); ok {
+				pos = expr.Pos
+			}
+
+			nodes := append([]Node{fun}, expression.Nodes[i+1:]...)
+			ret = ExpressionNode{Type: SExpression, Nodes: nodes, Pos: pos}.Evaluate(env)
+		}
+
+		err, ok := ret.(ErrorNode)
+		if ok {
+			return nil, err.Error
+		}
+
+		if called {
+			break
+		}
+	}
+
+	return ret, nil
+}
+
+// pushFile records that source read from file is being evaluated, until the
+// matching popFile.
+func (env *Environment) pushFile(file string) {
+	env.state.files = append(env.state.files, file)
+}
+
+func (env *Environment) popFile() {
+	env.state.files = env.state.files[:len(env.state.files)-1]
+}
@@ -0,0 +1,56 @@
+package lisp
+
+import "testing"
+
+func TestFunctionFactories(t *testing.T) {
+	runTests(t, []evalTest{
+		{`(fun {adder n} {fn {x} {+ x n}}) ((adder 5) 3)`, "8"},
+		{`(fun {adder n} {fn {x} {+ x n}}) (def {a5} (adder 5)) (def {a1} (adder 1)) (list (a5 1) (a1 1))`, "{6 2}"},
+		// Functions see the n they were created with, not the caller's.
+		{`(fun {adder n} {fn {x} {+ x n}}) (fun {call f n} {f 1}) (call (adder 5) 50)`, "6"},
+		{`(fun {make x} {do (let {secret} x) (fn {y} {+ secret y})}) ((make 40) 2)`, "42"},
+	})
+}
+
+func TestCounters(t *testing.T) {
+	runTests(t, []evalTest{
+		{`(fun {counter n} {fn {step} {do (set {n} (+ n step)) n}}) (def {c} (counter 10)) (c 1) (c 1)`, "12"},
+		{`(fun {counter start} {do (let {r} (ref start)) (fn {step} {swap! r + step})}) (def {c} (counter 0)) (c 1) (c 2) (c 3)`, "6"},
+		{`(fun {counter start} {do (let {r} (ref start)) (fn {step} {swap! r + step})}) (def {a} (counter 0)) (def {b} (counter 100)) (a 1) (b 1) (list (a 1) (b 1))`, "{2 102}"},
+	})
+}
+
+func TestPartialApplication(t *testing.T) {
+	runTests(t, []evalTest{
+		{`(fun {add a b} {+ a b}) (map (add 1) {1 2 3})`, "{2 3 4}"},
+		{`(fun {add a b} {+ a b}) (def {add5} (add 5)) (add5 1) (add5 2)`, "7"},
+		{`(fun {add3 a b c} {+ a b c}) (((add3 1) 2) 3)`, "6"},
+	})
+}
+
+func TestDefLeavesGlobalsAlone(t *testing.T) {
+	runTests(t, []evalTest{
+		{`(def {g} 1) (fun {f x} {do (def {g} x) g}) (list (f 3) g)`, "{3 1}"},
+		{`(fun {f x} {def {g} x}) (f 3) (try {g} {e} {error-message e})`, `"unknown identifier g"`},
+		{`(fun {f x} {do (fun {double y} {* y 2}) (double x)}) (list (f 2) (try {double 1} {e} {error-message e}))`, `{4 "unknown identifier double"}`},
+		{`(fun {f x} {do (try {raise "x"} {e} {def {h} x}) h}) (list (f 5) (try {h} {e} {error-message e}))`, `{5 "unknown identifier h"}`},
+		{`(fun {f x} {do (def {y} x) (fn {z} {+ y z})}) ((f 1) 2)`, "3"},
+		// Outside of functions, def defines in the module.
+		{`(try {raise "x"} {e} {def {h} 1}) h`, "1"},
+		{`(fun {f x} {do (let {l} x) (bool l)}) (f 3) (try {l} {e} {error-message e})`, `"unknown identifier l"`},
+	})
+}
+
+// TestTailCalls loops deeper than DefaultMaxDepth, which only works if calls
+// in tail position don't nest.
+func TestTailCalls(t *testing.T) {
+	runTests(t, []evalTest{
+		{`(fun {loop n} {if (= n 0) {"done"} {loop (- n 1)}}) (loop 20000)`, `"done"`},
+		{`(fun {loop n} {select {(= n 0) "done"} {else (loop (- n 1))}}) (loop 20000)`, `"done"`},
+		{`(fun {loop n} {switch n {0 "done"} {n (curry loop (list (- n 1)))}}) (loop 20000)`, `"done"`},
+		{`(fun {loop n} {do (let {m} (- n 1)) (if (= m 0) {"done"} {loop m})}) (loop 20000)`, `"done"`},
+		{`(fun {loop n} {unless (= n 0) (loop (- n 1))}) (loop 20000)`, "()"},
+		{`(fun {loop n acc} {if (= n 0) {acc} {eval {loop (- n 1) (+ acc 1)}}}) (loop 20000 0)`, "20000"},
+		{`(fun {even? n} {if (= n 0) {true} {odd? (- n 1)}}) (fun {odd? n} {if (= n 0) {false} {even? (- n 1)}}) (even? 20001)`, "false"},
+	})
+}
@@ -0,0 +1,37 @@
+package lisp
+
+import "testing"
+
+// run evaluates input like the REPL does, in a new environment with the
+// standard library, and returns the printed value of the last expression.
+func run(t *testing.T, input string) string {
+	t.Helper()
+
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := EvaluateInput(&env, input)
+	if err != nil {
+		t.Fatalf("%v: %v", input, err)
+	}
+
+	return out.String()
+}
+
+type evalTest struct {
+	input string
+	want  string
+}
+
+func runTests(t *testing.T, tests []evalTest) {
+	t.Helper()
+
+	for _, test := range tests {
+		got := run(t, test.input)
+		if got != test.want {
+			t.Errorf("%v = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
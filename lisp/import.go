@@ -0,0 +1,367 @@
+package lisp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Extension is the file extension of source files. Import adds it to paths
+// that don't already end with it.
+const Extension = ".clsp"
+
+// PathVariable is the environment variable listing the directories import
+// searches after the directory of the importing file.
+const PathVariable = "CLISP_PATH"
+
+// ImportNotFound is returned when import can't find a file in any of the
+// directories it searched.
+type ImportNotFound struct {
+	Path     string
+	Searched []string
+}
+
+func (e ImportNotFound) Error() string {
+	return fmt.Sprintf("cannot find %q, searched %v", e.Path, strings.Join(e.Searched, ", "))
+}
+
+// ImportCycle is returned when a module requires a module that is still being
+// loaded.
+type ImportCycle struct {
+	Path string
+}
+
+func (e ImportCycle) Error() string {
+	return fmt.Sprintf("import cycle, %q is required while it is being loaded", e.Path)
+}
+
+// NotExported is returned when require asks for a name a module doesn't
+// export.
+type NotExported struct {
+	Path string
+	Name IdentifierNode
+}
+
+func (e NotExported) Error() string {
+	return fmt.Sprintf("%q does not export %v", e.Path, e.Name)
+}
+
+// module is the part of a module that lives on its root environment.
+type module struct {
+	path string
+	// loaded holds the absolute paths of the files imported into the module.
+	loaded map[string]bool
+	// exports is nil if the module never called export, in which case
+	// everything it defines is exported.
+	exports []IdentifierNode
+	// prelude holds the names the standard library defined that the module
+	// hasn't redefined, which aren't exported unless listed explicitly.
+	prelude map[IdentifierNode]bool
+	ready   bool
+}
+
+func newModule(path string) *module {
+	return &module{path: path, loaded: make(map[string]bool), prelude: make(map[IdentifierNode]bool)}
+}
+
+// moduleRoot returns the root environment of the module env belongs to.
+func (env *Environment) moduleRoot() *Environment {
+	for env.module == nil && env.Parent != nil {
+		env = env.Parent
+	}
+
+	return env
+}
+
+// newModuleEnvironment creates the root environment for the module at path.
+// It can't see anything defined by env, only the builtins available to it and
+// the standard library if env has it.
+func newModuleEnvironment(env *Environment, path string) (*Environment, error) {
+	builtins := Environment{values: make(map[IdentifierNode]Node), state: env.state}
+	for e := env.moduleRoot(); e != nil; e = e.Parent {
+		for id, value := range e.values {
+			fun, ok := value.(FunctionNode)
+			if _, seen := builtins.values[id]; !seen && ok && fun.Builtin != nil {
+				builtins.values[id] = value
+			}
+		}
+	}
+
+	modEnv := NewEnvironment(&builtins)
+	modEnv.module = newModule(path)
+
+	if env.state.stdlib {
+		err := modEnv.LoadStdlib()
+		if err != nil {
+			return nil, err
+		}
+
+		for id := range modEnv.values {
+			modEnv.module.prelude[id] = true
+		}
+	}
+
+	return &modEnv, nil
+}
+
+// exported returns the names a module exports, sorted.
+func (env *Environment) exported() []IdentifierNode {
+	names := env.module.exports
+	if names == nil {
+		names = make([]IdentifierNode, 0, len(env.values))
+		for id := range env.values {
+			if !env.module.prelude[id] {
+				names = append(names, id)
+			}
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// currentDir returns the directory relative paths are resolved against, which
+// is the directory of the file being evaluated, or the working directory if
+// the source didn't come from a file. In a sandbox, that is its import
+// directory instead.
+func (env *Environment) currentDir() string {
+	files := env.state.files
+	if len(files) == 0 || files[len(files)-1] == "" {
+		if env.state.limits != nil {
+			return env.state.limits.ImportDir
+		}
+
+		return "."
+	}
+
+	return filepath.Dir(files[len(files)-1])
+}
+
+// resolveImport finds the file path refers to. Absolute paths are used as is,
+// relative ones are looked up next to the importing file and then in each
+// directory of CLISP_PATH. A sandbox only allows files in its import
+// directory, and ignores CLISP_PATH.
+func (env *Environment) resolveImport(path string) (string, error) {
+	if !strings.HasSuffix(path, Extension) {
+		path += Extension
+	}
+
+	dirs := []string{""}
+	if !filepath.IsAbs(path) {
+		dirs = []string{env.currentDir()}
+		if env.state.limits == nil {
+			for _, dir := range filepath.SplitList(os.Getenv(PathVariable)) {
+				if dir != "" {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+	}
+
+	searched := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, path)
+		if err := env.confineImport(candidate); err != nil {
+			return "", err
+		}
+
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+
+		searched = append(searched, candidate)
+	}
+
+	return "", ImportNotFound{path, searched}
+}
+
+// Import evaluates a file in env, sharing its definitions with the importing
+// module. A file that has already been imported into the module, or is being
+// imported, isn't evaluated again.
+func Import(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	path, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	resolved, err := env.resolveImport(string(path))
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	loaded := env.moduleRoot().module.loaded
+	if loaded[resolved] {
+		return ExpressionNode{Type: SExpression}
+	}
+
+	file, err := os.ReadFile(resolved)
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	loaded[resolved] = true
+
+	_, err = EvaluateSource(env.moduleRoot(), resolved, string(file), true)
+	if err != nil {
+		// Allow importing the file again once the error is fixed.
+		delete(loaded, resolved)
+		return ErrorNode{err}
+	}
+
+	return ExpressionNode{Type: SExpression}
+}
+
+// loadModule returns the root environment of the module at path, evaluating
+// the module the first time it is required.
+func (env *Environment) loadModule(path string) (*Environment, error) {
+	resolved, err := env.resolveImport(path)
+	if err != nil {
+		return nil, err
+	}
+
+	modEnv, ok := env.state.modules[resolved]
+	if ok {
+		if !modEnv.module.ready {
+			return nil, ImportCycle{resolved}
+		}
+
+		return modEnv, nil
+	}
+
+	file, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	modEnv, err = newModuleEnvironment(env, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	env.state.modules[resolved] = modEnv
+
+	_, err = EvaluateSource(modEnv, resolved, string(file), true)
+	if err != nil {
+		delete(env.state.modules, resolved)
+		return nil, err
+	}
+
+	modEnv.module.ready = true
+
+	return modEnv, nil
+}
+
+// Require loads a module and defines what it exports. Given a string, the
+// exports are defined with that prefix and a slash, like str/split. Given a
+// Q-Expression of names, only those are defined, without prefix. Without
+// either, the prefix is the name of the file.
+func Require(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	path, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	modEnv, err := env.loadModule(string(path))
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	exports := modEnv.exported()
+	prefix := strings.TrimSuffix(filepath.Base(string(path)), Extension) + "/"
+	names := exports
+
+	if len(args) == 2 {
+		switch arg := args[1].(type) {
+		case StringNode:
+			prefix = string(arg) + "/"
+		case ExpressionNode:
+			if arg.Type != QExpression {
+				return ErrorNode{typeError("String or Q-Expression", arg)}
+			}
+
+			prefix = ""
+			names = make([]IdentifierNode, 0, len(arg.Nodes))
+			for _, node := range arg.Nodes {
+				name, ok := node.(IdentifierNode)
+				if !ok {
+					return ErrorNode{typeError("Identifier", node)}
+				}
+
+				if !containsName(exports, name) {
+					return ErrorNode{NotExported{modEnv.module.path, name}}
+				}
+
+				names = append(names, name)
+			}
+		default:
+			return ErrorNode{typeError("String or Q-Expression", arg)}
+		}
+	}
+
+	for _, name := range names {
+		value, ok := modEnv.values[name]
+		if !ok {
+			return ErrorNode{fmt.Errorf("%q exports %v, but never defines it", modEnv.module.path, name)}
+		}
+
+		env.Def(IdentifierNode(prefix)+name, value)
+	}
+
+	return ExpressionNode{Type: SExpression}
+}
+
+func containsName(names []IdentifierNode, name IdentifierNode) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Export declares which of the names defined by the current module require
+// makes available to other modules.
+func Export(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	expr, ok := args[0].(ExpressionNode)
+	if !ok || expr.Type != QExpression {
+		return ErrorNode{typeError("Q-Expression", args[0])}
+	}
+
+	module := env.moduleRoot().module
+
+	if module.exports == nil {
+		module.exports = make([]IdentifierNode, 0, len(expr.Nodes))
+	}
+
+	for _, node := range expr.Nodes {
+		name, ok := node.(IdentifierNode)
+		if !ok {
+			return ErrorNode{typeError("Identifier", node)}
+		}
+
+		if !containsName(module.exports, name) {
+			module.exports = append(module.exports, name)
+		}
+	}
+
+	return ExpressionNode{Type: SExpression}
+}
@@ -0,0 +1,124 @@
+package lisp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the given files, with paths relative to a new temporary
+// directory, and returns the directory. DIR in the tests is replaced by it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func inDir(dir string, tests []evalTest) []evalTest {
+	ret := make([]evalTest, len(tests))
+	for i, test := range tests {
+		ret[i] = evalTest{strings.ReplaceAll(test.input, "DIR", filepath.ToSlash(dir)), test.want}
+	}
+
+	return ret
+}
+
+func TestImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.clsp":        `(import "lib/util")`,
+		"lib/util.clsp":    `(import "helper") (def {util} (+ helper 1))`,
+		"lib/helper.clsp":  `(def {helper} 41)`,
+		"count.clsp":       `(def {count} (+ count 1))`,
+		"broken.clsp":      `(def {count} (+ count 1)) (head 1)`,
+		"path/search.clsp": `(def {found} true)`,
+	})
+
+	runTests(t, inDir(dir, []evalTest{
+		// Paths are relative to the importing file, the extension is optional.
+		{`(import "DIR/main") (list helper util)`, "{41 42}"},
+		{`(import "DIR/lib/helper.clsp") helper`, "41"},
+		// A file is only evaluated once per module.
+		{`(def {count} 0) (import "DIR/count") (import "DIR/count.clsp") count`, "1"},
+		// Unless it failed, so it can be imported again once fixed.
+		{`(def {count} 0) (try {import "DIR/broken"} {e} {()}) (try {import "DIR/broken"} {e} {()}) count`, "2"},
+		{`try {import "DIR/missing"} {e} {error-type e}`, `"import"`},
+		{`try {import "DIR/lib"} {e} {error-type e}`, `"import"`},
+		{`try {import "search"} {e} {error-type e}`, `"import"`},
+	}))
+
+	old, set := os.LookupEnv(PathVariable)
+	defer func() {
+		if set {
+			os.Setenv(PathVariable, old)
+		} else {
+			os.Unsetenv(PathVariable)
+		}
+	}()
+
+	os.Setenv(PathVariable, filepath.Join(dir, "lib")+string(filepath.ListSeparator)+filepath.Join(dir, "path"))
+
+	runTests(t, []evalTest{
+		{`(import "search") found`, "true"},
+		{`(import "helper") helper`, "41"},
+	})
+}
+
+func TestRequire(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"str.clsp":     `(export {shout}) (fun {shout s} {upper (secret s)}) (fun {secret s} {s})`,
+		"a.clsp":       `(fun {name x} {"a"})`,
+		"b.clsp":       `(fun {name x} {"b"})`,
+		"state.clsp":   `(def {counter} (ref 0))`,
+		"peek.clsp":    `(fun {peek x} {outside})`,
+		"cycle/a.clsp": `(require "b")`,
+		"cycle/b.clsp": `(require "a")`,
+	})
+
+	runTests(t, inDir(dir, []evalTest{
+		{`(require "DIR/str") (str/shout "a")`, `"A"`},
+		{`(require "DIR/str" "s") (s/shout "a")`, `"A"`},
+		{`(require "DIR/str" {shout}) (shout "a")`, `"A"`},
+		{`(require "DIR/str") (try {str/secret "a"} {e} {error-message e})`, `"unknown identifier str/secret"`},
+		{`try {require "DIR/str" {secret}} {e} {error-type e}`, `"import"`},
+		// Modules have their own namespace, and don't see the importer's.
+		{`(require "DIR/a") (require "DIR/b") (list (a/name 1) (b/name 1))`, `{"a" "b"}`},
+		{`(fun {name x} {"main"}) (require "DIR/a" {name}) (name 1)`, `"a"`},
+		{`(def {outside} 1) (require "DIR/peek" {peek}) (try {peek 1} {e} {error-message e})`, `"unknown identifier outside"`},
+		// A module is loaded once, however it is required.
+		{`(require "DIR/state" {counter}) (swap! counter + 1) (require "DIR/state.clsp" "again") (deref again/counter)`, "1"},
+		{`try {require "DIR/cycle/a"} {e} {error-type e}`, `"import"`},
+	}))
+
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = EvaluateInput(&env, `require "`+filepath.ToSlash(dir)+`/cycle/a"`)
+
+	var cycle ImportCycle
+	if !errors.As(err, &cycle) || filepath.Base(cycle.Path) != "a.clsp" {
+		t.Errorf("got %v, want ImportCycle for a.clsp", err)
+	}
+
+	_, err = EvaluateInput(&env, `require "`+filepath.ToSlash(dir)+`/str" {secret}`)
+
+	var export NotExported
+	if !errors.As(err, &export) || export.Name != "secret" {
+		t.Errorf("got %v, want NotExported for secret", err)
+	}
+}
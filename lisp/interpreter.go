@@ -0,0 +1,161 @@
+package lisp
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// Interpreter is the entry point for programs embedding clisp. It owns a root
+// environment with the builtins defined, and converts between Go values and
+// nodes where needed.
+type Interpreter struct {
+	env Environment
+}
+
+// NewInterpreter creates an interpreter with the builtins defined.
+func NewInterpreter() *Interpreter {
+	env := NewEnvironment(nil)
+	env.AddBuiltins()
+
+	return &Interpreter{env}
+}
+
+// NewInterpreterWithStdlib creates an interpreter with the builtins and the
+// standard library defined.
+func NewInterpreterWithStdlib() (*Interpreter, error) {
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Interpreter{env}, nil
+}
+
+// NewSandboxedInterpreter creates an interpreter with the builtins the sandbox
+// allows defined, and its limits applied. See NewSandboxedEnvironment.
+func NewSandboxedInterpreter(sandbox Sandbox) (*Interpreter, error) {
+	env, err := NewSandboxedEnvironment(sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Interpreter{env}, nil
+}
+
+// Environment returns the root environment of the interpreter, for example to
+// change its output.
+func (in *Interpreter) Environment() *Environment {
+	return &in.env
+}
+
+// Eval evaluates input like the REPL does and returns the value of the last
+// expression. See EvaluateInput.
+func (in *Interpreter) Eval(input string) (Node, error) {
+	return EvaluateInput(&in.env, input)
+}
+
+// EvalFile evaluates every expression in the file at path and returns the
+// value of the last one.
+func (in *Interpreter) EvalFile(path string) (Node, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluateAll(&in.env, path, string(source), false)
+}
+
+// Call calls the function bound to name. The arguments are converted with
+// ToNode.
+func (in *Interpreter) Call(name string, args ...interface{}) (_ Node, err error) {
+	defer recoverError(&err)
+	defer in.env.startBudget()()
+
+	value := in.env.Get(IdentifierNode(name))
+	if value == nil {
+		return nil, fmt.Errorf("unknown identifier %v", name)
+	}
+
+	fun, ok := value.(FunctionNode)
+	if !ok {
+		return nil, typeError("Function", value)
+	}
+
+	nodes := make([]Node, len(args))
+	for i, arg := range args {
+		node, err := ToNode(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %v: %w", i+1, err)
+		}
+
+		nodes[i] = node
+	}
+
+	ret := fun.call(&in.env, Position{}, nodes)
+
+	failed, ok := ret.(ErrorNode)
+	if ok {
+		return nil, failed.Error
+	}
+
+	return ret, nil
+}
+
+// Set binds name to value, converted with ToNode.
+func (in *Interpreter) Set(name string, value interface{}) error {
+	node, err := ToNode(value)
+	if err != nil {
+		return err
+	}
+
+	in.env.Def(IdentifierNode(name), node)
+	return nil
+}
+
+// Get returns the value bound to name, or false if name is unbound.
+func (in *Interpreter) Get(name string) (Node, bool) {
+	node := in.env.Get(IdentifierNode(name))
+	return node, node != nil
+}
+
+// GetAs stores the value bound to name in the value ptr points to, converting
+// it with FromNode.
+func (in *Interpreter) GetAs(name string, ptr interface{}) error {
+	node, ok := in.Get(name)
+	if !ok {
+		return fmt.Errorf("unknown identifier %v", name)
+	}
+
+	target := reflect.ValueOf(ptr)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer, got %T", ptr)
+	}
+
+	value, err := FromNode(&in.env, node, target.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	target.Elem().Set(value)
+	return nil
+}
+
+// Register binds name to a builtin calling fn, which must be a Go function.
+// Arguments are converted to the parameter types of fn with FromNode, and
+// results back with ToNode. fn may take the calling *Environment as its first
+// parameter, and return an error as its last result, which is raised if it
+// isn't nil. fn must take at least one other parameter. For example:
+//
+//	in.Register("greet", func(name string) string {
+//		return "hello " + name
+//	})
+func (in *Interpreter) Register(name string, fn interface{}) error {
+	builtin, err := wrapFunc(reflect.ValueOf(fn))
+	if err != nil {
+		return err
+	}
+
+	in.env.Def(IdentifierNode(name), FunctionNode{Name: name, Builtin: builtin})
+	return nil
+}
@@ -0,0 +1,157 @@
+package lisp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// SetOutput sets where print and println write to.
+func (env *Environment) SetOutput(w io.Writer) {
+	env.state.output = w
+}
+
+// SetInput sets where read-line reads from.
+func (env *Environment) SetInput(r io.Reader) {
+	env.state.input = bufio.NewReader(r)
+}
+
+func (env *Environment) reader() *bufio.Reader {
+	if env.state.input == nil {
+		env.state.input = bufio.NewReader(os.Stdin)
+	}
+
+	return env.state.input
+}
+
+func write(env *Environment, args []Node, end string) Node {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = display(arg)
+	}
+
+	_, err := io.WriteString(env.state.output, strings.Join(parts, " ")+end)
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	return ExpressionNode{Type: SExpression}
+}
+
+// Print writes its arguments separated by spaces.
+func Print(env *Environment, args []Node) Node {
+	return write(env, args, "")
+}
+
+// Println writes its arguments separated by spaces, followed by a newline.
+func Println(env *Environment, args []Node) Node {
+	return write(env, args, "\n")
+}
+
+// ReadLine writes a prompt and reads a line of input, without the line
+// ending. Since a function can't be called without arguments, the prompt is
+// required, but may be empty.
+func ReadLine(env *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	_, writeErr := io.WriteString(env.state.output, s[0])
+	if writeErr != nil {
+		return ErrorNode{writeErr}
+	}
+
+	line, readErr := env.reader().ReadString('\n')
+	if readErr != nil && (readErr != io.EOF || line == "") {
+		return ErrorNode{readErr}
+	}
+
+	return StringNode(strings.TrimRight(line, "\r\n"))
+}
+
+func ReadFile(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	content, readErr := os.ReadFile(s[0])
+	if readErr != nil {
+		return ErrorNode{readErr}
+	}
+
+	return StringNode(content)
+}
+
+// writeFile implements write-file and append-file.
+func writeFile(args []Node, flag int) Node {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	file, openErr := os.OpenFile(s[0], os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if openErr != nil {
+		return ErrorNode{openErr}
+	}
+
+	_, writeErr := file.WriteString(s[1])
+	closeErr := file.Close()
+
+	if writeErr != nil {
+		return ErrorNode{writeErr}
+	}
+
+	if closeErr != nil {
+		return ErrorNode{closeErr}
+	}
+
+	return ExpressionNode{Type: SExpression}
+}
+
+func WriteFile(_ *Environment, args []Node) Node {
+	return writeFile(args, os.O_TRUNC)
+}
+
+func AppendFile(_ *Environment, args []Node) Node {
+	return writeFile(args, os.O_APPEND)
+}
+
+func FileExists(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	_, statErr := os.Stat(s[0])
+	if errors.Is(statErr, os.ErrNotExist) {
+		return BoolNode(false)
+	} else if statErr != nil {
+		return ErrorNode{statErr}
+	}
+
+	return BoolNode(true)
+}
+
+// ListDir returns the names of the entries in a directory, sorted.
+func ListDir(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	entries, readErr := os.ReadDir(s[0])
+	if readErr != nil {
+		return ErrorNode{readErr}
+	}
+
+	nodes := make([]Node, len(entries))
+	for i, entry := range entries {
+		nodes[i] = StringNode(entry.Name())
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
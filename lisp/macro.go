@@ -0,0 +1,132 @@
+package lisp
+
+import (
+	"errors"
+)
+
+// lambda creates the function or macro described by a list of formals and a
+// body.
+func lambda(env *Environment, args []Node, macro bool) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	for _, n := range args {
+		expr, ok := n.(ExpressionNode)
+		if !ok || expr.Type != QExpression {
+			return ErrorNode{typeError("Q-Expression", n)}
+		}
+	}
+
+	nodes := args[0].(ExpressionNode).Nodes
+	formals := make([]IdentifierNode, 0, len(nodes))
+	for _, node := range nodes {
+		i, ok := node.(IdentifierNode)
+		if !ok {
+			return ErrorNode{typeError("Identifier", node)}
+		}
+
+		formals = append(formals, i)
+	}
+
+	return FunctionNode{
+		Environment: env,
+		Formals:     formals,
+		Body:        args[1].(ExpressionNode),
+		Macro:       macro,
+	}
+}
+
+// Macro creates a macro. Its arguments are bound to the formals without being
+// evaluated, and the value of its body is evaluated in place of the call, the
+// way eval would.
+func Macro(env *Environment, args []Node) Node {
+	return lambda(env, args, true)
+}
+
+// expand calls the macro f with args, and returns its expansion as a tail
+// call.
+func (f FunctionNode) expand(env *Environment, args []Node) Node {
+	expansion := f.apply(env, args)
+
+	tail, ok := expansion.(tailCall)
+	if ok {
+		expansion = tail.Evaluate(env)
+	}
+
+	_, ok = expansion.(ErrorNode)
+	if ok {
+		return expansion
+	}
+
+	return Eval(env, []Node{expansion})
+}
+
+// Do evaluates its arguments in order and returns the value of the last one.
+// It is a macro, so that the last argument is evaluated as a tail call.
+func Do(env *Environment, args []Node) Node {
+	if len(args) == 0 {
+		return ExpressionNode{Type: SExpression}
+	}
+
+	for _, arg := range args[:len(args)-1] {
+		value := arg.Evaluate(env)
+		if _, ok := value.(ErrorNode); ok {
+			return value
+		}
+	}
+
+	return ExpressionNode{Type: SExpression, Nodes: args[len(args)-1:]}
+}
+
+// quasiquote fills in the template node, replacing the unquoted parts with
+// their values in env.
+func quasiquote(env *Environment, node Node) Node {
+	if err := env.state.enter(); err != nil {
+		return ErrorNode{err}
+	}
+	defer env.state.leave()
+
+	switch v := node.(type) {
+	case UnquoteNode:
+		if v.Splice {
+			return ErrorNode{errors.New("cannot splice outside of a list")}
+		}
+
+		return v.Node.Evaluate(env)
+	case ExpressionNode:
+		nodes := make([]Node, 0, len(v.Nodes))
+		for _, n := range v.Nodes {
+			unquote, ok := n.(UnquoteNode)
+			if !ok || !unquote.Splice {
+				value := quasiquote(env, n)
+				if _, ok := value.(ErrorNode); ok {
+					return value
+				}
+
+				nodes = append(nodes, value)
+				continue
+			}
+
+			value := unquote.Node.Evaluate(env)
+			if _, ok := value.(ErrorNode); ok {
+				return value
+			}
+
+			list, ok := value.(ExpressionNode)
+			if !ok || list.Type == MapExpression {
+				return ErrorNode{typeError("Q-Expression or S-Expression", value)}
+			}
+
+			if err := env.checkLength(len(nodes) + len(list.Nodes)); err != nil {
+				return ErrorNode{err}
+			}
+
+			nodes = append(nodes, list.Nodes...)
+		}
+
+		return ExpressionNode{Type: v.Type, Nodes: nodes, Pos: v.Pos}
+	default:
+		return node
+	}
+}
@@ -0,0 +1,294 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+type mapEntry struct {
+	Key   Node
+	Value Node
+}
+
+// MapNode maps keys to values. Keys are strings, numbers or booleans. Maps are
+// never modified in place, builtins like assoc return an updated copy.
+type MapNode struct {
+	entries map[string]mapEntry
+}
+
+func NewMap() MapNode {
+	return MapNode{make(map[string]mapEntry)}
+}
+
+func (_ MapNode) TypeString() string {
+	return "Map"
+}
+
+func (m MapNode) String() string {
+	return printNode(m)
+}
+
+// mapKey returns the string key is stored under, or an error if key can't be
+// used as a map key. Numbers that are exactly equal are the same key, so 1 and
+// 1.0 are, but 0.1 and 1/10 aren't, as 0.1 isn't exactly a tenth.
+func mapKey(key Node) (string, error) {
+	switch v := key.(type) {
+	case StringNode, BoolNode:
+		return key.TypeString() + ":" + key.String(), nil
+	case IntNode, BigIntNode, RatNode:
+		return "Number:" + key.String(), nil
+	case FloatNode:
+		f := float64(v)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return "Number:" + key.String(), nil
+		}
+
+		return "Number:" + new(big.Rat).SetFloat64(f).RatString(), nil
+	default:
+		return "", typeError("String, Number or Boolean", key)
+	}
+}
+
+// lessKey orders map keys, numbers by value and anything else by type and
+// then by string representation.
+func lessKey(a Node, b Node) bool {
+	if isNumber(a) && isNumber(b) {
+		c := compareNumbers(a, b)
+		if c != 0 {
+			return c < 0
+		}
+	}
+
+	if a.TypeString() != b.TypeString() {
+		return a.TypeString() < b.TypeString()
+	}
+
+	return a.String() < b.String()
+}
+
+// sorted returns the entries of m in a stable order.
+func (m MapNode) sorted() []mapEntry {
+	entries := make([]mapEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return lessKey(entries[i].Key, entries[j].Key)
+	})
+
+	return entries
+}
+
+func (m MapNode) Len() int {
+	return len(m.entries)
+}
+
+// Get returns the value stored under key, or nil if there is none.
+func (m MapNode) Get(key Node) Node {
+	k, err := mapKey(key)
+	if err != nil {
+		return nil
+	}
+
+	entry, ok := m.entries[k]
+	if !ok {
+		return nil
+	}
+
+	return entry.Value
+}
+
+func (m MapNode) copy() MapNode {
+	ret := MapNode{make(map[string]mapEntry, len(m.entries))}
+	for k, entry := range m.entries {
+		ret.entries[k] = entry
+	}
+
+	return ret
+}
+
+// Assoc returns a copy of m with key set to value.
+func (m MapNode) Assoc(key Node, value Node) (MapNode, error) {
+	k, err := mapKey(key)
+	if err != nil {
+		return MapNode{}, err
+	}
+
+	ret := m.copy()
+	ret.entries[k] = mapEntry{key, value}
+	return ret, nil
+}
+
+// evaluateMap evaluates the keys and values of a map literal.
+func evaluateMap(e ExpressionNode, env *Environment) Node {
+	if len(e.Nodes)%2 != 0 {
+		return ErrorNode{fmt.Errorf("%v: map literal needs a value for every key", e.Pos)}
+	}
+
+	if err := env.checkLength(len(e.Nodes) / 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	if err := env.state.enter(); err != nil {
+		return ErrorNode{err}
+	}
+	defer env.state.leave()
+
+	ret := NewMap()
+	for i := 0; i < len(e.Nodes); i += 2 {
+		key := e.Nodes[i].Evaluate(env)
+		_, ok := key.(ErrorNode)
+		if ok {
+			return key
+		}
+
+		value := e.Nodes[i+1].Evaluate(env)
+		_, ok = value.(ErrorNode)
+		if ok {
+			return value
+		}
+
+		k, err := mapKey(key)
+		if err != nil {
+			return ErrorNode{err}
+		}
+
+		ret.entries[k] = mapEntry{key, value}
+	}
+
+	return ret
+}
+
+func Get(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 3); err != nil {
+		return ErrorNode{err}
+	}
+
+	m, ok := args[0].(MapNode)
+	if !ok {
+		return ErrorNode{typeError("Map", args[0])}
+	}
+
+	_, err := mapKey(args[1])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	value := m.Get(args[1])
+	if value != nil {
+		return value
+	}
+
+	if len(args) == 3 {
+		return args[2]
+	}
+
+	return ErrorNode{DomainError{fmt.Sprintf("key %v not found", args[1])}}
+}
+
+func Assoc(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 3, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
+	if len(args)%2 != 1 {
+		return ErrorNode{DomainError{fmt.Sprintf("missing a value for key %v", args[len(args)-1])}}
+	}
+
+	m, ok := args[0].(MapNode)
+	if !ok {
+		return ErrorNode{typeError("Map", args[0])}
+	}
+
+	m = m.copy()
+	for i := 1; i < len(args); i += 2 {
+		k, err := mapKey(args[i])
+		if err != nil {
+			return ErrorNode{err}
+		}
+
+		m.entries[k] = mapEntry{args[i], args[i+1]}
+	}
+
+	return m
+}
+
+func Dissoc(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
+	m, ok := args[0].(MapNode)
+	if !ok {
+		return ErrorNode{typeError("Map", args[0])}
+	}
+
+	m = m.copy()
+	for _, key := range args[1:] {
+		k, err := mapKey(key)
+		if err != nil {
+			return ErrorNode{err}
+		}
+
+		delete(m.entries, k)
+	}
+
+	return m
+}
+
+func Keys(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	m, ok := args[0].(MapNode)
+	if !ok {
+		return ErrorNode{typeError("Map", args[0])}
+	}
+
+	nodes := make([]Node, 0, m.Len())
+	for _, entry := range m.sorted() {
+		nodes = append(nodes, entry.Key)
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+func Vals(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	m, ok := args[0].(MapNode)
+	if !ok {
+		return ErrorNode{typeError("Map", args[0])}
+	}
+
+	nodes := make([]Node, 0, m.Len())
+	for _, entry := range m.sorted() {
+		nodes = append(nodes, entry.Value)
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+func Has(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	m, ok := args[0].(MapNode)
+	if !ok {
+		return ErrorNode{typeError("Map", args[0])}
+	}
+
+	_, err := mapKey(args[1])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	return BoolNode(m.Get(args[1]) != nil)
+}
@@ -0,0 +1,20 @@
+package lisp
+
+import "testing"
+
+func TestMapKeys(t *testing.T) {
+	runTests(t, []evalTest{
+		{`get #{1 "a"} 1.0`, `"a"`},
+		{`get #{1.0 "a"} 1`, `"a"`},
+		{`get #{0.5 "a"} (/ 1 2)`, `"a"`},
+		{`get #{9223372036854775808 "a"} 9223372036854775808.0`, `"a"`},
+		{`get #{0 "a"} -0.0`, `"a"`},
+		{`len (keys #{1 "a" 1.0 "b" (/ 2 2) "c"})`, "1"},
+		{`has? (assoc #{} 2 "a") 2.0`, "true"},
+		{`dissoc #{1 "a" 2 "b"} 1.0`, `#{2 "b"}`},
+		// 0.1 isn't exactly a tenth, or "1".
+		{`has? #{(/ 1 10) "a"} 0.1`, "false"},
+		{`has? #{1 "a"} "1"`, "false"},
+		{`has? #{true "a"} 1`, "false"},
+	})
+}
@@ -0,0 +1,385 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// IntNode is an integer that fits in 64 bits. Arithmetic that overflows it
+// promotes the result to a BigIntNode.
+type IntNode int64
+
+func (_ IntNode) TypeString() string {
+	return "Integer"
+}
+
+func (i IntNode) String() string {
+	return strconv.FormatInt(int64(i), 10)
+}
+
+// BigIntNode is an integer that doesn't fit in an IntNode.
+type BigIntNode struct {
+	Value *big.Int
+}
+
+func (_ BigIntNode) TypeString() string {
+	return "Integer"
+}
+
+func (i BigIntNode) String() string {
+	return i.Value.String()
+}
+
+// RatNode is an exact fraction, the result of dividing integers that don't
+// divide evenly.
+type RatNode struct {
+	Value *big.Rat
+}
+
+func (_ RatNode) TypeString() string {
+	return "Rational"
+}
+
+func (r RatNode) String() string {
+	return r.Value.RatString()
+}
+
+type FloatNode float64
+
+func (_ FloatNode) TypeString() string {
+	return "Float"
+}
+
+func (f FloatNode) String() string {
+	ret := strconv.FormatFloat(float64(f), 'g', -1, 64)
+
+	// Keep floats with integral values recognizable as floats.
+	if !strings.ContainsAny(ret, ".eIN") {
+		ret += ".0"
+	}
+
+	return ret
+}
+
+// Ranks of the number types. Arithmetic on two numbers converts both to the
+// higher ranked type.
+const (
+	notNumber = iota
+	intRank
+	bigIntRank
+	ratRank
+	floatRank
+)
+
+func numberRank(node Node) int {
+	switch node.(type) {
+	case IntNode:
+		return intRank
+	case BigIntNode:
+		return bigIntRank
+	case RatNode:
+		return ratRank
+	case FloatNode:
+		return floatRank
+	default:
+		return notNumber
+	}
+}
+
+func isNumber(node Node) bool {
+	return numberRank(node) != notNumber
+}
+
+func toBigInt(node Node) *big.Int {
+	switch v := node.(type) {
+	case IntNode:
+		return big.NewInt(int64(v))
+	case BigIntNode:
+		return v.Value
+	default:
+		return nil
+	}
+}
+
+func toRat(node Node) *big.Rat {
+	switch v := node.(type) {
+	case IntNode:
+		return new(big.Rat).SetInt64(int64(v))
+	case BigIntNode:
+		return new(big.Rat).SetInt(v.Value)
+	case RatNode:
+		return v.Value
+	default:
+		return nil
+	}
+}
+
+func toFloat(node Node) float64 {
+	switch v := node.(type) {
+	case IntNode:
+		return float64(v)
+	case BigIntNode:
+		f, _ := new(big.Float).SetInt(v.Value).Float64()
+		return f
+	case RatNode:
+		f, _ := v.Value.Float64()
+		return f
+	case FloatNode:
+		return float64(v)
+	default:
+		return math.NaN()
+	}
+}
+
+// bigIntNode returns i as an IntNode if it fits, and a BigIntNode otherwise.
+func bigIntNode(i *big.Int) Node {
+	if i.IsInt64() {
+		return IntNode(i.Int64())
+	}
+
+	return BigIntNode{i}
+}
+
+// ratNode returns r as an integer if it is one, and a RatNode otherwise.
+func ratNode(r *big.Rat) Node {
+	if r.IsInt() {
+		return bigIntNode(new(big.Int).Set(r.Num()))
+	}
+
+	return RatNode{r}
+}
+
+// ParseNumber parses a number literal. Literals with a decimal point are
+// floats, ones with a slash fractions and anything else is an integer.
+func ParseNumber(literal string) (Node, error) {
+	if strings.Contains(literal, "/") {
+		r, ok := new(big.Rat).SetString(literal)
+		if !ok {
+			return nil, fmt.Errorf("invalid fraction %q", literal)
+		}
+
+		return ratNode(r), nil
+	}
+
+	if strings.ContainsAny(literal, ".eE") {
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return FloatNode(f), nil
+	}
+
+	i, err := strconv.ParseInt(literal, 10, 64)
+	if err == nil {
+		return IntNode(i), nil
+	}
+
+	value, ok := new(big.Int).SetString(literal, 10)
+	if !ok {
+		return nil, err
+	}
+
+	return BigIntNode{value}, nil
+}
+
+// integerBits returns the number of bits of an integer, or the larger of the
+// numerator and denominator of a fraction, and 0 for other nodes.
+func integerBits(node Node) int {
+	switch v := node.(type) {
+	case BigIntNode:
+		return v.Value.BitLen()
+	case RatNode:
+		bits := v.Value.Num().BitLen()
+		if v.Value.Denom().BitLen() > bits {
+			bits = v.Value.Denom().BitLen()
+		}
+
+		return bits
+	default:
+		return 0
+	}
+}
+
+var errDivisionByZero = DomainError{"division by zero"}
+
+// calculate applies op, which is one of + - * / or %, to two numbers. Both
+// are converted to the higher ranked type of the two first.
+func calculate(op byte, a Node, b Node) Node {
+	rank := numberRank(a)
+	if numberRank(b) > rank {
+		rank = numberRank(b)
+	}
+
+	switch rank {
+	case intRank:
+		ret, ok := calculateInt(op, int64(a.(IntNode)), int64(b.(IntNode)))
+		if ok {
+			return ret
+		}
+
+		return calculateBigInt(op, toBigInt(a), toBigInt(b))
+	case bigIntRank:
+		return calculateBigInt(op, toBigInt(a), toBigInt(b))
+	case ratRank:
+		return calculateRat(op, toRat(a), toRat(b))
+	default:
+		return calculateFloat(op, toFloat(a), toFloat(b))
+	}
+}
+
+// calculateInt returns false if the result doesn't fit in an IntNode.
+func calculateInt(op byte, a int64, b int64) (Node, bool) {
+	switch op {
+	case '+':
+		c := a + b
+		return IntNode(c), (c > a) == (b > 0)
+	case '-':
+		c := a - b
+		return IntNode(c), (c < a) == (b > 0)
+	case '*':
+		if a == 0 || b == 0 {
+			return IntNode(0), true
+		}
+
+		c := a * b
+		return IntNode(c), c/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	case '/':
+		if b == 0 {
+			return ErrorNode{errDivisionByZero}, true
+		}
+
+		if a%b != 0 {
+			return ratNode(big.NewRat(a, b)), true
+		}
+
+		return IntNode(a / b), !(a == math.MinInt64 && b == -1)
+	default:
+		if b == 0 {
+			return ErrorNode{errDivisionByZero}, true
+		}
+
+		return IntNode(a % b), true
+	}
+}
+
+func calculateBigInt(op byte, a *big.Int, b *big.Int) Node {
+	switch op {
+	case '+':
+		return bigIntNode(new(big.Int).Add(a, b))
+	case '-':
+		return bigIntNode(new(big.Int).Sub(a, b))
+	case '*':
+		return bigIntNode(new(big.Int).Mul(a, b))
+	case '/':
+		if b.Sign() == 0 {
+			return ErrorNode{errDivisionByZero}
+		}
+
+		return ratNode(new(big.Rat).SetFrac(a, b))
+	default:
+		if b.Sign() == 0 {
+			return ErrorNode{errDivisionByZero}
+		}
+
+		return bigIntNode(new(big.Int).Rem(a, b))
+	}
+}
+
+func calculateRat(op byte, a *big.Rat, b *big.Rat) Node {
+	switch op {
+	case '+':
+		return ratNode(new(big.Rat).Add(a, b))
+	case '-':
+		return ratNode(new(big.Rat).Sub(a, b))
+	case '*':
+		return ratNode(new(big.Rat).Mul(a, b))
+	case '/':
+		if b.Sign() == 0 {
+			return ErrorNode{errDivisionByZero}
+		}
+
+		return ratNode(new(big.Rat).Quo(a, b))
+	default:
+		if b.Sign() == 0 {
+			return ErrorNode{errDivisionByZero}
+		}
+
+		// Like for integers, the remainder takes the sign of a.
+		quo := new(big.Rat).Quo(a, b)
+		trunc := new(big.Int).Quo(quo.Num(), quo.Denom())
+		return ratNode(new(big.Rat).Sub(a, new(big.Rat).Mul(b, new(big.Rat).SetInt(trunc))))
+	}
+}
+
+func calculateFloat(op byte, a float64, b float64) Node {
+	switch op {
+	case '+':
+		return FloatNode(a + b)
+	case '-':
+		return FloatNode(a - b)
+	case '*':
+		return FloatNode(a * b)
+	case '/':
+		if b == 0 {
+			return ErrorNode{errDivisionByZero}
+		}
+
+		return FloatNode(a / b)
+	default:
+		if b == 0 {
+			return ErrorNode{errDivisionByZero}
+		}
+
+		return FloatNode(math.Mod(a, b))
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 if a is less than, equal to or greater
+// than b. Floats are compared as floats, all other numbers exactly.
+func compareNumbers(a Node, b Node) int {
+	x, ok1 := a.(IntNode)
+	y, ok2 := b.(IntNode)
+	if ok1 && ok2 {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	if numberRank(a) == floatRank || numberRank(b) == floatRank {
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return toRat(a).Cmp(toRat(b))
+}
+
+func isZero(node Node) bool {
+	switch v := node.(type) {
+	case IntNode:
+		return v == 0
+	case BigIntNode:
+		return v.Value.Sign() == 0
+	case RatNode:
+		return v.Value.Sign() == 0
+	case FloatNode:
+		return v == 0
+	default:
+		return false
+	}
+}
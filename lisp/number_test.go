@@ -0,0 +1,45 @@
+package lisp
+
+import "testing"
+
+func TestNumberPromotion(t *testing.T) {
+	runTests(t, []evalTest{
+		{`+ 9223372036854775807 0`, "9223372036854775807"},
+		{`+ 9223372036854775807 1`, "9223372036854775808"},
+		{`+ -9223372036854775808 -1`, "-9223372036854775809"},
+		{`+ -9223372036854775808 0`, "-9223372036854775808"},
+		{`- -9223372036854775808 1`, "-9223372036854775809"},
+		{`- 9223372036854775807 -1`, "9223372036854775808"},
+		{`- 0 -9223372036854775808`, "9223372036854775808"},
+		{`- 5 0`, "5"},
+		{`* 4611686018427387904 2`, "9223372036854775808"},
+		{`* -4611686018427387904 2`, "-9223372036854775808"},
+		{`* -9223372036854775808 -1`, "9223372036854775808"},
+		{`* -1 -9223372036854775808`, "9223372036854775808"},
+		{`* 3037000500 3037000500`, "9223372037000250000"},
+		{`/ -9223372036854775808 -1`, "9223372036854775808"},
+		{`% -9223372036854775808 -1`, "0"},
+		{`% -7 2`, "-1"},
+		// Results that fit again are ints.
+		{`- 9223372036854775808 1`, "9223372036854775807"},
+		{`/ 9223372036854775808 2`, "4611686018427387904"},
+		{`% 9223372036854775809 2`, "1"},
+		// Division is exact.
+		{`/ 6 3`, "2"},
+		{`/ 1 3`, "1/3"},
+		{`/ -2 4`, "-1/2"},
+		{`+ (/ 1 3) (/ 2 3)`, "1"},
+		{`* (/ 1 3) 3`, "1"},
+		{`format "%T" (* (/ 1 3) 3)`, `"Integer"`},
+		{`/ 9223372036854775808 3`, "9223372036854775808/3"},
+		{`% (/ 7 2) 2`, "3/2"},
+		{`% (/ -7 2) 2`, "-3/2"},
+		// Floats win over everything else.
+		{`+ (/ 1 2) 0.5`, "1.0"},
+		{`format "%T" (+ (/ 1 2) 0.5)`, `"Float"`},
+		{`+ 9223372036854775808 0.5`, "9.223372036854776e+18"},
+		{`< (/ 1 3) 0.34`, "true"},
+		{`< 9223372036854775807 9223372036854775808`, "true"},
+		{`= (/ 1 2) 0.5`, "true"},
+	})
+}
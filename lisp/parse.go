@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type Node interface {
@@ -17,11 +19,13 @@ type ExpressionType uint8
 const (
 	SExpression ExpressionType = iota
 	QExpression
+	MapExpression
 )
 
 type ExpressionNode struct {
 	Type  ExpressionType
 	Nodes []Node
+	Pos   Position
 }
 
 func (e ExpressionNode) TypeString() string {
@@ -30,37 +34,125 @@ func (e ExpressionNode) TypeString() string {
 		return "S-Expression"
 	case QExpression:
 		return "Q-Expression"
+	case MapExpression:
+		return "Map Expression"
 	default:
 		return "Expression"
 	}
 }
 
 func (e ExpressionNode) String() string {
-	ret := ""
+	return printNode(e)
+}
 
-	switch e.Type {
-	case SExpression:
-		ret += "("
-	case QExpression:
-		ret += "{"
+// printNode returns the printed form of node. Values nested inside it are
+// printed from an explicit stack instead of recursively, so however deep they
+// nest, printing them can't overflow the Go stack.
+func printNode(node Node) string {
+	s, _ := printNodeLimited(node, 0)
+	return s
+}
+
+// printNodeLimited is printNode, except that if max is positive it gives up
+// once more than max characters are printed, returning what it printed so far
+// and false.
+func printNodeLimited(node Node, max int) (string, bool) {
+	var b strings.Builder
+	// length is the number of characters in the first counted bytes of b.
+	length, counted := 0, 0
+
+	// pending holds what is left to print, the next item last. Strings are
+	// written as they are, nodes in their printed form.
+	pending := []interface{}{node}
+	push := func(items ...interface{}) {
+		for i := len(items) - 1; i >= 0; i-- {
+			pending = append(pending, items[i])
+		}
 	}
 
-	for i, node := range e.Nodes {
-		if i != 0 {
-			ret += " "
+	for len(pending) > 0 {
+		if max > 0 {
+			length += utf8.RuneCountInString(b.String()[counted:])
+			counted = b.Len()
+			if length > max {
+				return b.String(), false
+			}
 		}
 
-		ret += node.String()
+		item := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		switch v := item.(type) {
+		case string:
+			b.WriteString(v)
+		case ExpressionNode:
+			switch v.Type {
+			case SExpression:
+				b.WriteString("(")
+				pending = append(pending, ")")
+			case QExpression:
+				b.WriteString("{")
+				pending = append(pending, "}")
+			case MapExpression:
+				b.WriteString("#{")
+				pending = append(pending, "}")
+			}
+
+			for i := len(v.Nodes) - 1; i >= 0; i-- {
+				pending = append(pending, v.Nodes[i])
+				if i != 0 {
+					pending = append(pending, " ")
+				}
+			}
+		case MapNode:
+			b.WriteString("#{")
+			pending = append(pending, "}")
+
+			entries := v.sorted()
+			for i := len(entries) - 1; i >= 0; i-- {
+				push(entries[i].Key, " ", entries[i].Value)
+				if i != 0 {
+					pending = append(pending, " ")
+				}
+			}
+		case FunctionNode:
+			switch {
+			case v.Builtin != nil:
+				b.WriteString("<builtin>")
+			case v.Macro:
+				fmt.Fprintf(&b, "(macro %v ", v.Formals)
+				push(v.Body, ")")
+			default:
+				fmt.Fprintf(&b, "(fn %v ", v.Formals)
+				push(v.Body, ")")
+			}
+		case QuasiquoteNode:
+			b.WriteString("`")
+			push(v.Node)
+		case UnquoteNode:
+			if v.Splice {
+				b.WriteString(",@")
+			} else {
+				b.WriteString(",")
+			}
+
+			push(v.Node)
+		case ErrorValueNode:
+			fmt.Fprintf(&b, "(error %q", v.Message)
+			pending = append(pending, ")")
+			if v.Payload != nil {
+				push(" ", v.Payload)
+			}
+		case Node:
+			b.WriteString(v.String())
+		}
 	}
 
-	switch e.Type {
-	case SExpression:
-		ret += ")"
-	case QExpression:
-		ret += "}"
+	if max > 0 && length+utf8.RuneCountInString(b.String()[counted:]) > max {
+		return b.String(), false
 	}
 
-	return ret
+	return b.String(), true
 }
 
 type IdentifierNode string
@@ -73,14 +165,18 @@ func (i IdentifierNode) String() string {
 	return string(i)
 }
 
-type NumberNode float64
+type BoolNode bool
 
-func (_ NumberNode) TypeString() string {
-	return "Number"
+func (_ BoolNode) TypeString() string {
+	return "Boolean"
 }
 
-func (v NumberNode) String() string {
-	return fmt.Sprintf("%g", v)
+func (b BoolNode) String() string {
+	if b {
+		return "true"
+	}
+
+	return "false"
 }
 
 type StringNode string
@@ -106,10 +202,13 @@ func (e ErrorNode) String() string {
 }
 
 type FunctionNode struct {
+	Name        string
 	Builtin     Builtin
 	Environment *Environment
 	Formals     []IdentifierNode
 	Body        ExpressionNode
+	// Macro is set for functions that receive their arguments unevaluated.
+	Macro bool
 }
 
 func (_ FunctionNode) TypeString() string {
@@ -117,130 +216,339 @@ func (_ FunctionNode) TypeString() string {
 }
 
 func (f FunctionNode) String() string {
-	if f.Builtin != nil {
-		return "<builtin>"
-	} else {
-		return fmt.Sprintf("(fn %v %v)", f.Formals, f.Body)
-	}
+	return printNode(f)
+}
+
+// QuasiquoteNode is a template, written as `x. Evaluating it returns x with
+// the unquoted parts inside replaced by their values.
+type QuasiquoteNode struct {
+	Node Node
+}
+
+func (_ QuasiquoteNode) TypeString() string {
+	return "Quasiquote"
+}
+
+func (q QuasiquoteNode) String() string {
+	return printNode(q)
+}
+
+// UnquoteNode is written as ,x inside a template to insert the value of x,
+// or as ,@x to splice the elements of the list x into the surrounding one.
+type UnquoteNode struct {
+	Node   Node
+	Splice bool
+}
+
+func (_ UnquoteNode) TypeString() string {
+	return "Unquote"
+}
+
+func (u UnquoteNode) String() string {
+	return printNode(u)
 }
 
 type UnexpectedToken Token
 
 func (t UnexpectedToken) Error() string {
-	return fmt.Sprintf("unexpected token in input: %v", t.Value)
+	return fmt.Sprintf("%v: unexpected token in input: %v", t.Pos, t.Value)
 }
 
-type UnexpectedEOI struct{}
+// UnexpectedEOI is returned when the input ends before a bracket is closed.
+// Pos points at the bracket that was left open.
+type UnexpectedEOI struct {
+	Pos Position
+}
+
+func (e UnexpectedEOI) Error() string {
+	if !e.Pos.IsValid() {
+		return "unexpected end of input"
+	}
+
+	return fmt.Sprintf("%v: unexpected end of input, bracket is never closed", e.Pos)
+}
 
-func (_ UnexpectedEOI) Error() string {
-	return "unexpected end of input"
+// InvalidLiteral is returned when a number or string literal can't be parsed.
+// Kind is "number" or "string".
+type InvalidLiteral struct {
+	Pos  Position
+	Kind string
+	Err  error
 }
 
-func trimWhitespace(input *[]Token) bool {
-	if len(*input) == 0 {
-		return false
+func (e InvalidLiteral) Error() string {
+	return fmt.Sprintf("%v: failed to parse %v, %v", e.Pos, e.Kind, e.Err)
+}
+
+func (e InvalidLiteral) Unwrap() error {
+	return e.Err
+}
+
+// ErrorPosition returns the source position of a tokenization or parsing
+// error, if err carries one.
+func ErrorPosition(err error) (Position, bool) {
+	var char UnexpectedCharacter
+	if errors.As(err, &char) {
+		return char.Pos, true
+	}
+
+	var token UnexpectedToken
+	if errors.As(err, &token) {
+		return token.Pos, true
+	}
+
+	var eoi UnexpectedEOI
+	if errors.As(err, &eoi) && eoi.Pos.IsValid() {
+		return eoi.Pos, true
 	}
 
-	if (*input)[0].Type == WhitespaceToken {
-		*input = (*input)[1:]
-		trimWhitespace(input)
-		return true
-	} else {
-		return false
+	var literal InvalidLiteral
+	if errors.As(err, &literal) {
+		return literal.Pos, true
 	}
+
+	return Position{}, false
 }
 
-func findMatchingClose(input []Token, type_ ExpressionType) (int, error) {
-	depth := 0
+// parseLimits bounds what the parser accepts, for sandboxes. Zero fields are
+// unlimited.
+type parseLimits struct {
+	// maxDepth is how deep brackets may nest.
+	maxDepth int
+	// maxLength is how many elements a list, entries a map and characters a
+	// string literal may have.
+	maxLength int
+	// maxIntegerBits is how many bits an integer literal may have.
+	maxIntegerBits int
+}
 
-	for i, token := range input {
-		switch token.Type {
-		case OpenToken:
-			depth++
-		case CloseToken:
-			depth--
+// openExpression is an expression whose closing bracket hasn't been reached
+// yet.
+type openExpression struct {
+	expr  ExpressionNode
+	close string
+	// quotes are the quote tokens waiting for the next element of expr.
+	quotes []Token
+}
 
-			if depth == 0 {
-				switch type_ {
-				case SExpression:
-					if token.Value != ")" {
-						return 0, UnexpectedToken(token)
-					}
-				case QExpression:
-					if token.Value != "}" {
-						return 0, UnexpectedToken(token)
-					}
-				}
+// add appends node to the expression, quoted by the quotes waiting for it.
+func (o *openExpression) add(node Node) {
+	for i := len(o.quotes) - 1; i >= 0; i-- {
+		switch o.quotes[i].Value {
+		case "`":
+			node = QuasiquoteNode{node}
+		case ",":
+			node = UnquoteNode{node, false}
+		default:
+			node = UnquoteNode{node, true}
+		}
+	}
+
+	o.quotes = o.quotes[:0]
+	o.expr.Nodes = append(o.expr.Nodes, node)
+}
+
+// checkLength returns an error if the expression has more elements than
+// limits allow. The expression holding all of the input isn't a literal, and
+// may have any number.
+func (o *openExpression) checkLength(limits parseLimits) error {
+	if o.close == "" {
+		return nil
+	}
 
-				return i, nil
+	length := len(o.expr.Nodes)
+	if o.expr.Type == MapExpression {
+		length = (length + 1) / 2
+	}
+
+	if limits.maxLength > 0 && length > limits.maxLength {
+		return fmt.Errorf("%v: %w", o.expr.Pos, LengthLimitExceeded{length, limits.maxLength})
+	}
+
+	return nil
+}
+
+// parseAtom parses a number, string, boolean or identifier token.
+func parseAtom(token Token, limits parseLimits) (Node, error) {
+	switch token.Type {
+	case IdentifierToken:
+		return IdentifierNode(token.Value), nil
+	case BoolToken:
+		return BoolNode(token.Value == "true"), nil
+	case NumberToken:
+		if limits.maxIntegerBits > 0 && !strings.ContainsAny(token.Value, ".eE") {
+			// Every digit after the first adds more than three bits, so
+			// integers that are clearly too large aren't parsed at all.
+			digits := strings.TrimLeft(token.Value, "+-0")
+			if bits := (len(digits) - 1) * 3; bits > limits.maxIntegerBits {
+				return nil, fmt.Errorf("%v: %w", token.Pos, IntegerLimitExceeded{bits, limits.maxIntegerBits})
 			}
 		}
-	}
 
-	return 0, UnexpectedEOI{}
+		value, err := ParseNumber(token.Value)
+		if err != nil {
+			return nil, InvalidLiteral{token.Pos, "number", err}
+		}
+
+		if bits := integerBits(value); limits.maxIntegerBits > 0 && bits > limits.maxIntegerBits {
+			return nil, fmt.Errorf("%v: %w", token.Pos, IntegerLimitExceeded{bits, limits.maxIntegerBits})
+		}
+
+		return value, nil
+	case StringToken:
+		// Strings may span several lines, which strconv doesn't allow.
+		literal := strings.NewReplacer("\n", `\n`, "\r", `\r`).Replace(token.Value)
+		value, err := strconv.Unquote(literal)
+		if err != nil {
+			return nil, InvalidLiteral{token.Pos, "string", err}
+		}
+
+		if limits.maxLength > 0 && len(value) > limits.maxLength {
+			length := utf8.RuneCountInString(value)
+			if length > limits.maxLength {
+				return nil, fmt.Errorf("%v: %w", token.Pos, LengthLimitExceeded{length, limits.maxLength})
+			}
+		}
+
+		return StringNode(value), nil
+	default:
+		return nil, UnexpectedToken(token)
+	}
 }
 
-func ParseExpression(input []Token, type_ ExpressionType) (ExpressionNode, error) {
-	trimWhitespace(&input)
+// parseTokens parses input into an expression of the given type, in a single
+// pass that keeps the expressions still open on a stack.
+func parseTokens(input []Token, type_ ExpressionType, limits parseLimits) (ExpressionNode, error) {
+	root := &openExpression{expr: ExpressionNode{Type: type_}}
+	for _, token := range input {
+		if token.Type != WhitespaceToken {
+			root.expr.Pos = token.Pos
+			break
+		}
+	}
+
+	stack := []*openExpression{root}
+	// separated is false right after an element, which must be followed by
+	// whitespace or a closing bracket before the next one starts.
+	separated := true
 
-	ret := ExpressionNode{Type: type_}
+	for _, token := range input {
+		top := stack[len(stack)-1]
 
-	for {
-		if len(input) == 0 {
-			return ret, nil
+		// A quote must be followed directly by what it quotes.
+		if len(top.quotes) > 0 && (token.Type == WhitespaceToken || token.Type == CloseToken) {
+			return ExpressionNode{}, UnexpectedToken(token)
 		}
 
-		switch input[0].Type {
-		case IdentifierToken:
-			ret.Nodes = append(ret.Nodes, IdentifierNode(input[0].Value))
-			input = input[1:]
-		case NumberToken:
-			value, _ := strconv.ParseFloat(input[0].Value, 64)
-			ret.Nodes = append(ret.Nodes, NumberNode(value))
-
-			input = input[1:]
-		case StringToken:
-			value, err := strconv.Unquote(input[0].Value)
-			if err != nil {
-				return ExpressionNode{}, fmt.Errorf("failed to parse string, %v", err)
+		switch token.Type {
+		case WhitespaceToken:
+			separated = true
+			continue
+		case CloseToken:
+			if len(stack) == 1 || token.Value != top.close {
+				return ExpressionNode{}, UnexpectedToken(token)
 			}
 
-			ret.Nodes = append(ret.Nodes, StringNode(value))
-			input = input[1:]
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			parent.add(top.expr)
+			if err := parent.checkLength(limits); err != nil {
+				return ExpressionNode{}, err
+			}
+
+			separated = false
+			continue
+		}
+
+		if !separated {
+			return ExpressionNode{}, UnexpectedToken(token)
+		}
+
+		switch token.Type {
+		case QuoteToken:
+			top.quotes = append(top.quotes, token)
 		case OpenToken:
-			var type_ ExpressionType
-			switch input[0].Value {
-			case "(":
-				type_ = SExpression
+			if limits.maxDepth > 0 && len(stack) > limits.maxDepth {
+				return ExpressionNode{}, fmt.Errorf("%v: %w", token.Pos, MaxDepthExceeded{limits.maxDepth})
+			}
+
+			open := &openExpression{expr: ExpressionNode{Type: SExpression, Pos: token.Pos}, close: ")"}
+			switch token.Value {
 			case "{":
-				type_ = QExpression
-			default:
-				return ExpressionNode{}, errors.New("unknown expression type for open bracket " + input[0].Value)
+				open.expr.Type, open.close = QExpression, "}"
+			case "#{":
+				open.expr.Type, open.close = MapExpression, "}"
 			}
 
-			closeIndex, err := findMatchingClose(input, type_)
+			stack = append(stack, open)
+		default:
+			node, err := parseAtom(token, limits)
 			if err != nil {
 				return ExpressionNode{}, err
 			}
 
-			nestedExpression, err := ParseExpression(input[1:closeIndex], type_)
-			if err != nil {
+			top.add(node)
+			if err := top.checkLength(limits); err != nil {
 				return ExpressionNode{}, err
 			}
 
-			ret.Nodes = append(ret.Nodes, nestedExpression)
-
-			input = input[closeIndex+1:]
-		default:
-			return ExpressionNode{}, UnexpectedToken(input[0])
+			separated = false
 		}
+	}
 
-		if !trimWhitespace(&input) {
-			if len(input) != 0 {
-				return ExpressionNode{}, UnexpectedToken(input[0])
-			}
+	if len(stack) > 1 {
+		return ExpressionNode{}, UnexpectedEOI{stack[1].expr.Pos}
+	}
 
-			return ret, nil
+	if len(root.quotes) > 0 {
+		return ExpressionNode{}, UnexpectedToken(root.quotes[len(root.quotes)-1])
+	}
+
+	return root.expr, nil
+}
+
+func ParseExpression(input []Token, type_ ExpressionType) (ExpressionNode, error) {
+	return parseTokens(input, type_, parseLimits{})
+}
+
+// ParseSource tokenizes input, which was read from file, and parses it into
+// an expression of the given type.
+func ParseSource(file string, input string, type_ ExpressionType) (ExpressionNode, error) {
+	return parseSource(file, input, type_, parseLimits{})
+}
+
+func parseSource(file string, input string, type_ ExpressionType, limits parseLimits) (ExpressionNode, error) {
+	tokens, err := TokenizeSource(file, input)
+	if err != nil {
+		return ExpressionNode{}, fmt.Errorf("tokenization error: %w", err)
+	}
+
+	expression, err := parseTokens(tokens, type_, limits)
+	if err != nil {
+		return ExpressionNode{}, fmt.Errorf("parsing error: %w", err)
+	}
+
+	return expression, nil
+}
+
+// Incomplete reports whether input ends inside a string or with brackets left
+// open, meaning more input is needed before it can be parsed.
+func Incomplete(input string) bool {
+	tokens, err := Tokenize(input)
+	if err != nil {
+		var char UnexpectedCharacter
+		return errors.As(err, &char) && char.Char == '"'
+	}
+
+	depth := 0
+	for _, token := range tokens {
+		switch token.Type {
+		case OpenToken:
+			depth++
+		case CloseToken:
+			depth--
 		}
 	}
+
+	return depth > 0
 }
@@ -0,0 +1,84 @@
+package lisp
+
+import (
+	"fmt"
+)
+
+// RefNode is a mutable cell holding a value. Copies of a reference share the
+// cell, so it can hold state shared between functions.
+type RefNode struct {
+	cell *Node
+}
+
+func (_ RefNode) TypeString() string {
+	return "Reference"
+}
+
+// String identifies the reference without showing its value, which may
+// contain the reference itself.
+func (r RefNode) String() string {
+	return fmt.Sprintf("<ref %p>", r.cell)
+}
+
+func (r RefNode) Evaluate(_ *Environment) Node {
+	return r
+}
+
+func refArg(node Node) (RefNode, error) {
+	r, ok := node.(RefNode)
+	if !ok {
+		return RefNode{}, typeError("Reference", node)
+	}
+
+	return r, nil
+}
+
+// Ref creates a reference holding its argument.
+func Ref(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	value := args[0]
+	return RefNode{&value}
+}
+
+// Deref returns the value a reference holds.
+func Deref(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	r, err := refArg(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	return *r.cell
+}
+
+// Swap replaces the value of a reference by the result of calling a function
+// with the old value and any further arguments, and returns the new value.
+func Swap(env *Environment, args []Node) Node {
+	if err := checkArity(args, 2, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
+	r, err := refArg(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	f, ok := args[1].(FunctionNode)
+	if !ok {
+		return ErrorNode{typeError("Function", args[1])}
+	}
+
+	value := f.call(env, Position{}, append([]Node{*r.cell}, args[2:]...))
+	if _, ok := value.(ErrorNode); ok {
+		return value
+	}
+
+	*r.cell = value
+	return value
+}
@@ -0,0 +1,46 @@
+package lisp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelfReferencingRef(t *testing.T) {
+	env, err := NewEnvironmentWithStdlib()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = EvaluateInput(&env, `(def {r} (ref 0)) (swap! r (fn {old} {r})) (def {l} (ref {})) (swap! l (fn {old} {list l l}))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range []string{"r", "str r", "deref r", "deref l", "str l"} {
+		out, err := EvaluateInput(&env, input)
+		if err != nil {
+			t.Fatalf("%v: %v", input, err)
+		}
+
+		if !strings.Contains(out.String(), "<ref 0x") {
+			t.Errorf("%v = %v, want it to show the reference", input, out)
+		}
+	}
+
+	_, err = EvaluateInput(&env, "+ r 1")
+	if err == nil {
+		t.Fatal("+ r 1 succeeded")
+	}
+
+	if !strings.Contains(err.Error(), "in (+ <ref 0x") {
+		t.Errorf("error %q doesn't show the reference in its trace", err)
+	}
+}
+
+func TestRefIdentity(t *testing.T) {
+	runTests(t, []evalTest{
+		{`(def {r} (ref 1)) (= r r)`, "true"},
+		{`= (ref 1) (ref 1)`, "false"},
+		{`(def {r} (ref 1)) (swap! r + 2) (deref r)`, "3"},
+	})
+}
@@ -0,0 +1,378 @@
+package lisp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SafeBuiltins are the builtins a sandbox defines unless told otherwise:
+// everything except the builtins that read files or input.
+var SafeBuiltins = []string{
+	"+", "-", "*", "/", "=", "<", "<=", ">", ">=", "%",
+	"import", "require", "export",
+	"head", "tail", "post", "init", "list", "eval", "join",
+	"def", "let", "set", "ref", "deref", "swap!", "fn", "macro", "do", "if",
+	"error", "raise", "try", "error-message", "error-type", "error-payload",
+	"bool", "len", "first", "last", "nth", "take", "drop", "dropLast",
+	"reverse", "range", "map", "filter", "foldl", "reduce", "zip", "sort",
+	"strLen", "substr", "indexOf", "contains", "startsWith", "endsWith",
+	"split", "strJoin", "trim", "upper", "lower", "replace", "repeat",
+	"padLeft", "padRight", "str", "repr", "parse-number", "format",
+	"print", "println",
+	"get", "assoc", "dissoc", "keys", "vals", "has?",
+}
+
+// Sandbox describes the limits of an environment running untrusted code. The
+// zero value allows the safe builtins, no imports and unlimited time, steps
+// and sizes.
+type Sandbox struct {
+	// Builtins lists the builtins that are defined. If it is nil,
+	// SafeBuiltins are.
+	Builtins []string
+	// ImportDir is the only directory import and require may read files
+	// from. If it is empty, they can't read any file.
+	ImportDir string
+	// Timeout is how long evaluating a top level expression may take.
+	Timeout time.Duration
+	// MaxSteps is how many expressions evaluating a top level expression may
+	// evaluate.
+	MaxSteps int
+	// MaxLength is the maximum length of a list, map or string created by a
+	// builtin, a quasiquote or a literal, strings counted in characters.
+	MaxLength int
+	// MaxIntegerBits is the maximum number of bits of an integer, or of the
+	// numerator and denominator of a fraction. Integers that fit in 64 bits
+	// are always allowed.
+	MaxIntegerBits int
+	// MaxSourceLength is the maximum length in bytes of the source evaluated
+	// at once, like the input to EvaluateInput or an imported file.
+	MaxSourceLength int
+}
+
+// TimeLimitExceeded is returned when evaluation takes longer than the timeout
+// of a sandbox.
+type TimeLimitExceeded struct {
+	Timeout time.Duration
+}
+
+func (e TimeLimitExceeded) Error() string {
+	return fmt.Sprintf("time limit exceeded (%v)", e.Timeout)
+}
+
+// StepLimitExceeded is returned when evaluation takes more steps than a
+// sandbox allows.
+type StepLimitExceeded struct {
+	Steps int
+}
+
+func (e StepLimitExceeded) Error() string {
+	return fmt.Sprintf("step limit exceeded (%v)", e.Steps)
+}
+
+// LengthLimitExceeded is returned when evaluation would create a list, map or
+// string longer than a sandbox allows.
+type LengthLimitExceeded struct {
+	Length    int
+	MaxLength int
+}
+
+func (e LengthLimitExceeded) Error() string {
+	return fmt.Sprintf("length limit exceeded, %v is longer than %v", e.Length, e.MaxLength)
+}
+
+// IntegerLimitExceeded is returned when evaluation would create an integer or
+// fraction with more bits than a sandbox allows.
+type IntegerLimitExceeded struct {
+	Bits    int
+	MaxBits int
+}
+
+func (e IntegerLimitExceeded) Error() string {
+	return fmt.Sprintf("integer limit exceeded, %v bits are more than %v", e.Bits, e.MaxBits)
+}
+
+// SourceLimitExceeded is returned when source code is longer than a sandbox
+// allows.
+type SourceLimitExceeded struct {
+	Length    int
+	MaxLength int
+}
+
+func (e SourceLimitExceeded) Error() string {
+	return fmt.Sprintf("source limit exceeded, %v bytes are more than %v", e.Length, e.MaxLength)
+}
+
+// ImportNotAllowed is returned when a sandbox doesn't allow reading the file
+// at Path.
+type ImportNotAllowed struct {
+	Path string
+}
+
+func (e ImportNotAllowed) Error() string {
+	return fmt.Sprintf("importing %q is not allowed", e.Path)
+}
+
+// maxInt is the largest value of an int.
+const maxInt = int(^uint(0) >> 1)
+
+// timeCheckInterval is how many iterations builtins that loop for long go
+// between checking the time limit.
+const timeCheckInterval = 1024
+
+// limits is the sandbox of an environment, along with the budget of the
+// expression being evaluated.
+type limits struct {
+	Sandbox
+	steps    int
+	deadline time.Time
+	// running is set while a top level expression is evaluated, so nested
+	// entry points don't start a new budget.
+	running bool
+}
+
+// NewSandboxedEnvironment creates a root environment with the builtins the
+// sandbox allows defined, and its limits applied to everything evaluated in
+// it. The standard library can be loaded with LoadStdlib if the sandbox
+// allows the builtins it uses.
+func NewSandboxedEnvironment(sandbox Sandbox) (Environment, error) {
+	if sandbox.ImportDir != "" {
+		dir, err := filepath.Abs(sandbox.ImportDir)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		dir, err = filepath.EvalSymlinks(dir)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		sandbox.ImportDir = dir
+	}
+
+	allowed := sandbox.Builtins
+	if allowed == nil {
+		allowed = SafeBuiltins
+	}
+
+	env := NewEnvironment(nil)
+	env.AddBuiltins()
+	env.state.limits = &limits{Sandbox: sandbox}
+
+	keep := make(map[IdentifierNode]bool, len(allowed))
+	for _, name := range allowed {
+		keep[IdentifierNode(name)] = true
+	}
+
+	for id := range env.values {
+		if !keep[id] {
+			delete(env.values, id)
+		}
+	}
+
+	return env, nil
+}
+
+// startBudget starts the time and step budget of a top level expression,
+// unless one is already being evaluated. The returned function ends it.
+func (env *Environment) startBudget() func() {
+	l := env.state.limits
+	if l == nil || l.running {
+		return func() {}
+	}
+
+	l.running = true
+	l.steps = 0
+	if l.Timeout > 0 {
+		l.deadline = time.Now().Add(l.Timeout)
+	}
+
+	return func() { l.running = false }
+}
+
+// step counts an evaluation step against the budget.
+func (s *state) step() error {
+	l := s.limits
+	if l == nil {
+		return nil
+	}
+
+	l.steps++
+	if l.MaxSteps > 0 && l.steps > l.MaxSteps {
+		return StepLimitExceeded{l.MaxSteps}
+	}
+
+	if l.Timeout > 0 && time.Now().After(l.deadline) {
+		return TimeLimitExceeded{l.Timeout}
+	}
+
+	return nil
+}
+
+// checkTime returns an error once the time limit of the expression being
+// evaluated has passed. Builtins that loop for long call it every
+// timeCheckInterval iterations, i counting the iterations.
+func (env *Environment) checkTime(i int) error {
+	l := env.state.limits
+	if l == nil || l.Timeout <= 0 || i%timeCheckInterval != 0 {
+		return nil
+	}
+
+	if time.Now().After(l.deadline) {
+		return TimeLimitExceeded{l.Timeout}
+	}
+
+	return nil
+}
+
+// checkLength returns an error if the sandbox doesn't allow creating a list,
+// map or string of length n.
+func (env *Environment) checkLength(n int) error {
+	l := env.state.limits
+	if l == nil || l.MaxLength <= 0 || n <= l.MaxLength {
+		return nil
+	}
+
+	return LengthLimitExceeded{n, l.MaxLength}
+}
+
+// limitsLength reports whether the sandbox limits lengths, so builtins only
+// work out the length of their result when it is checked.
+func (env *Environment) limitsLength() bool {
+	l := env.state.limits
+	return l != nil && l.MaxLength > 0
+}
+
+// mulLength multiplies two lengths, saturating instead of overflowing, as the
+// limit is exceeded either way.
+func mulLength(a int, b int) int {
+	if a != 0 && b > maxInt/a {
+		return maxInt
+	}
+
+	return a * b
+}
+
+// addLength adds two lengths, saturating instead of overflowing.
+func addLength(a int, b int) int {
+	if a > maxInt-b {
+		return maxInt
+	}
+
+	return a + b
+}
+
+// printNode returns the String() form of node, without printing more of it
+// than the sandbox allows a string to be long.
+func (env *Environment) printNode(node Node) (string, error) {
+	if !env.limitsLength() {
+		return node.String(), nil
+	}
+
+	max := env.state.limits.MaxLength
+	s, ok := printNodeLimited(node, max)
+	if !ok {
+		return "", LengthLimitExceeded{utf8.RuneCountInString(s), max}
+	}
+
+	return s, nil
+}
+
+// displayNode is display, printing no more than printNode does.
+func (env *Environment) displayNode(node Node) (string, error) {
+	s, ok := node.(StringNode)
+	if ok {
+		return string(s), nil
+	}
+
+	return env.printNode(node)
+}
+
+// checkNumber returns an error if node is an integer or fraction with more
+// bits than the sandbox allows.
+func (env *Environment) checkNumber(node Node) error {
+	l := env.state.limits
+	if l == nil || l.MaxIntegerBits <= 0 {
+		return nil
+	}
+
+	bits := integerBits(node)
+	if bits > l.MaxIntegerBits {
+		return IntegerLimitExceeded{bits, l.MaxIntegerBits}
+	}
+
+	return nil
+}
+
+// checkResult checks the length of a value returned by a builtin, or built by
+// the evaluator, and the size of a number.
+func (env *Environment) checkResult(node Node) error {
+	if err := env.checkNumber(node); err != nil {
+		return err
+	}
+
+	if !env.limitsLength() {
+		return nil
+	}
+
+	l := env.state.limits
+
+	switch v := node.(type) {
+	case ExpressionNode:
+		return env.checkLength(len(v.Nodes))
+	case MapNode:
+		return env.checkLength(v.Len())
+	case StringNode:
+		if len(v) <= l.MaxLength {
+			return nil
+		}
+
+		return env.checkLength(utf8.RuneCountInString(string(v)))
+	}
+
+	return nil
+}
+
+// parse parses input like ParseSource, within the limits of the sandbox. The
+// source may be no longer than it allows, and brackets may nest no deeper than
+// the maximum depth. The lists, maps, strings and integers written literally
+// in it are checked like ones created by builtins.
+func (env *Environment) parse(file string, input string, type_ ExpressionType) (ExpressionNode, error) {
+	l := env.state.limits
+	if l == nil {
+		return ParseSource(file, input, type_)
+	}
+
+	if l.MaxSourceLength > 0 && len(input) > l.MaxSourceLength {
+		return ExpressionNode{}, SourceLimitExceeded{len(input), l.MaxSourceLength}
+	}
+
+	return parseSource(file, input, type_, parseLimits{env.state.maxDepth, l.MaxLength, l.MaxIntegerBits})
+}
+
+// confineImport returns an error unless the sandbox allows reading the file
+// at path. If the file exists, symbolic links are followed before checking.
+func (env *Environment) confineImport(path string) error {
+	l := env.state.limits
+	if l == nil {
+		return nil
+	}
+
+	resolved, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if target, err := filepath.EvalSymlinks(resolved); err == nil {
+		resolved = target
+	}
+
+	rel, err := filepath.Rel(l.ImportDir, resolved)
+	if l.ImportDir == "" || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ImportNotAllowed{path}
+	}
+
+	return nil
+}
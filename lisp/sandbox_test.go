@@ -0,0 +1,268 @@
+package lisp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sandboxed(t *testing.T, sandbox Sandbox) *Environment {
+	t.Helper()
+
+	env, err := NewSandboxedEnvironment(sandbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &env
+}
+
+func TestSandboxLengthLimit(t *testing.T) {
+	env := sandboxed(t, Sandbox{MaxLength: 1000, Timeout: time.Second})
+
+	for _, input := range []string{
+		`range 1 5000`,
+		`range 0 4611686018427387903`,
+		`repeat "ab" 600`,
+		`padLeft "a" 2000`,
+		`join (range 1 600) (range 1 600)`,
+		`join (repeat "a" 600) (repeat "a" 600)`,
+		"(def {double} (fn {xs} {`{,@xs ,@xs}})) (def {xs} {1}) (def {i} 0) (def {grow} (fn {n} {if (= n 0) {xs} {do (set {xs} (double xs)) (grow (- n 1))}})) (grow 40)",
+		`(def {l} (range 1 600)) (eval (join {list} l l))`,
+		`(def {s} (repeat "ab" 300)) (strJoin (list s s) "")`,
+		`(def {s} (repeat "a" 600)) (strJoin (list s "") (repeat "b" 600))`,
+		`(def {s} (repeat "a" 600)) (replace s "a" "bb")`,
+		`(def {s} (repeat "a" 600)) (replace s "" "b")`,
+		`(def {s} (repeat "a" 600)) (str s s)`,
+		`str (range 1 900)`,
+		`repr (range 1 900)`,
+		`format "%v" (range 1 900)`,
+		`format "%5000d" 1`,
+		`format "%.5000f" 1.0`,
+		`format "%99999999999999999999s" "a"`,
+		// Printing shared lists would take exponential time.
+		`(def {x} {1}) (def {grow} (fn {n} {if (= n 0) {x} {do (set {x} (list x x)) (grow (- n 1))}})) (grow 60) (str x)`,
+	} {
+		start := time.Now()
+		_, err := EvaluateInput(env, input)
+
+		var length LengthLimitExceeded
+		if !errors.As(err, &length) {
+			t.Errorf("%v: got %v, want LengthLimitExceeded", input, err)
+		}
+
+		if time.Since(start) > 500*time.Millisecond {
+			t.Errorf("%v took %v", input, time.Since(start))
+		}
+	}
+}
+
+func TestSandboxLiterals(t *testing.T) {
+	env := sandboxed(t, Sandbox{MaxLength: 1000})
+
+	list := func(n int) string {
+		return "{" + strings.Repeat("1 ", n) + "}"
+	}
+
+	for _, input := range []string{
+		list(1001),
+		"#{" + strings.Repeat("1 2 ", 1001) + "}",
+		`"` + strings.Repeat("é", 1001) + `"`,
+		"head {" + list(1001) + "}",
+		"(def {f} (fn {x} {x " + list(1001) + "})) 1",
+		"`{1 " + list(1001) + "}",
+		"`{1 ,(head " + list(1001) + ")}",
+		"#{1 " + list(1001) + "}",
+	} {
+		_, err := EvaluateInput(env, input)
+
+		var length LengthLimitExceeded
+		if !errors.As(err, &length) {
+			t.Errorf("%.30v: got %v, want LengthLimitExceeded", input, err)
+		}
+	}
+
+	for _, input := range []string{
+		list(1000),
+		"#{" + strings.Repeat("1 2 ", 1000) + "}",
+		`"` + strings.Repeat("é", 1000) + `"`,
+		"head {" + list(1000) + "}",
+	} {
+		_, err := EvaluateInput(env, input)
+		if err != nil {
+			t.Errorf("%.30v: %v", input, err)
+		}
+	}
+}
+
+func TestSandboxParseLimits(t *testing.T) {
+	env := sandboxed(t, Sandbox{MaxSourceLength: 100000})
+
+	start := time.Now()
+	_, err := EvaluateInput(env, strings.Repeat("{", 40000))
+
+	var depth MaxDepthExceeded
+	if !errors.As(err, &depth) {
+		t.Errorf("got %v, want MaxDepthExceeded", err)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("parsing took %v", time.Since(start))
+	}
+
+	_, err = EvaluateInput(env, "+ "+strings.Repeat("1 ", 50000))
+
+	var source SourceLimitExceeded
+	if !errors.As(err, &source) {
+		t.Errorf("got %v, want SourceLimitExceeded", err)
+	}
+}
+
+func TestSandboxIntegerLimit(t *testing.T) {
+	env := sandboxed(t, Sandbox{MaxIntegerBits: 1000, Timeout: time.Second})
+
+	for _, input := range []string{
+		strings.Repeat("9", 400),
+		"/ 1 " + strings.Repeat("9", 400),
+		`parse-number "` + strings.Repeat("9", 400) + `"`,
+		`(def {grow} (fn {x} {grow (* x x)})) (grow 3)`,
+		`(def {grow} (fn {x} {grow (+ x x)})) (grow 1)`,
+	} {
+		start := time.Now()
+		_, err := EvaluateInput(env, input)
+
+		var integer IntegerLimitExceeded
+		if !errors.As(err, &integer) {
+			t.Errorf("%.30v: got %v, want IntegerLimitExceeded", input, err)
+		}
+
+		if time.Since(start) > 500*time.Millisecond {
+			t.Errorf("%.30v took %v", input, time.Since(start))
+		}
+	}
+
+	for _, input := range []string{
+		"* " + strings.Repeat("9", 200) + " 9",
+		"1." + strings.Repeat("0", 400),
+	} {
+		if _, err := EvaluateInput(env, input); err != nil {
+			t.Errorf("%.30v: %v", input, err)
+		}
+	}
+
+	big := strings.Repeat("9", 200)
+	out, err := EvaluateInput(env, "try {* "+big+" "+big+" "+big+" "+big+"} {e} {error-type e}")
+	if err != nil || out.String() != `"limit"` {
+		t.Errorf("got %v, %v, want \"limit\"", out, err)
+	}
+}
+
+func TestSandboxStepLimit(t *testing.T) {
+	env := sandboxed(t, Sandbox{MaxSteps: 10000})
+
+	_, err := EvaluateInput(env, `(def {loop} (fn {n} {loop (+ n 1)})) (loop 0)`)
+
+	var steps StepLimitExceeded
+	if !errors.As(err, &steps) {
+		t.Fatalf("got %v, want StepLimitExceeded", err)
+	}
+
+	// Every top level expression gets a new budget.
+	out, err := EvaluateInput(env, "+ 1 2")
+	if err != nil || out.String() != "3" {
+		t.Fatalf("+ 1 2 = %v, %v", out, err)
+	}
+}
+
+func TestSandboxTimeLimit(t *testing.T) {
+	env := sandboxed(t, Sandbox{Timeout: 100 * time.Millisecond})
+
+	for _, input := range []string{
+		`(def {loop} (fn {n} {loop (+ n 1)})) (loop 0)`,
+		`range 0 4611686018427387903`,
+		`len (repeat "a" 4611686018427387903)`,
+	} {
+		start := time.Now()
+		_, err := EvaluateInput(env, input)
+
+		var limit TimeLimitExceeded
+		if !errors.As(err, &limit) {
+			t.Errorf("%v: got %v, want TimeLimitExceeded", input, err)
+		}
+
+		if time.Since(start) > time.Second {
+			t.Errorf("%v took %v", input, time.Since(start))
+		}
+	}
+}
+
+func TestSandboxBuiltins(t *testing.T) {
+	env := sandboxed(t, Sandbox{})
+
+	for _, name := range []IdentifierNode{"read-file", "write-file", "read-line", "list-dir"} {
+		if env.Get(name) != nil {
+			t.Errorf("%v is defined", name)
+		}
+	}
+
+	env = sandboxed(t, Sandbox{Builtins: []string{"+"}})
+	if env.Get("+") == nil || env.Get("-") != nil {
+		t.Errorf("builtins aren't limited to +")
+	}
+}
+
+func TestSandboxImports(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	outside := filepath.Join(dir, "outside")
+
+	for _, d := range []string{lib, outside} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(lib, "a.clsp"):     "(def {x} 42)",
+		filepath.Join(lib, "c.clsp"):     `(import "../outside/b")`,
+		filepath.Join(outside, "b.clsp"): "(def {y} 1)",
+	}
+
+	for path, source := range files {
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(outside, "b.clsp"), filepath.Join(lib, "link.clsp")); err != nil {
+		t.Fatal(err)
+	}
+
+	env := sandboxed(t, Sandbox{ImportDir: lib})
+
+	out, err := EvaluateInput(env, `(import "a") x`)
+	if err != nil || out.String() != "42" {
+		t.Fatalf(`import "a" gave %v, %v`, out, err)
+	}
+
+	for _, path := range []string{filepath.Join(outside, "b"), "../outside/b", "link", "c"} {
+		_, err := EvaluateInput(env, `import "`+path+`"`)
+
+		var notAllowed ImportNotAllowed
+		if !errors.As(err, &notAllowed) {
+			t.Errorf("import %v: got %v, want ImportNotAllowed", path, err)
+		}
+	}
+
+	env = sandboxed(t, Sandbox{})
+
+	_, err = EvaluateInput(env, `import "a"`)
+
+	var notAllowed ImportNotAllowed
+	if !errors.As(err, &notAllowed) {
+		t.Errorf("import without an import directory: got %v, want ImportNotAllowed", err)
+	}
+}
@@ -0,0 +1,24 @@
+package lisp
+
+import (
+	"lisp/lib"
+)
+
+// NewEnvironmentWithStdlib creates a root environment with the builtins and
+// the standard library defined.
+func NewEnvironmentWithStdlib() (Environment, error) {
+	env := NewEnvironment(nil)
+	env.AddBuiltins()
+
+	err := env.LoadStdlib()
+	return env, err
+}
+
+// LoadStdlib evaluates the standard library in env. Modules required from env
+// afterwards get the standard library as well.
+func (env *Environment) LoadStdlib() error {
+	env.state.stdlib = true
+
+	_, err := EvaluateSource(env, lib.StdFile, lib.Std, true)
+	return err
+}
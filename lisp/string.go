@@ -0,0 +1,488 @@
+package lisp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// stringArgs checks that args are exactly n strings, and returns them.
+func stringArgs(args []Node, n int) ([]string, Node) {
+	if err := checkArity(args, n, n); err != nil {
+		return nil, ErrorNode{err}
+	}
+
+	ret := make([]string, n)
+	for i, arg := range args {
+		s, ok := arg.(StringNode)
+		if !ok {
+			return nil, ErrorNode{typeError("String", arg)}
+		}
+
+		ret[i] = string(s)
+	}
+
+	return ret, nil
+}
+
+func StrLen(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	return IntNode(utf8.RuneCountInString(s[0]))
+}
+
+// Substr returns the characters of a string from a start index up to an
+// optional end index.
+func Substr(_ *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 3); err != nil {
+		return ErrorNode{err}
+	}
+
+	s, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	runes := []rune(s)
+
+	start, err := count(args[1])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	end := len(runes)
+	if len(args) == 3 {
+		end, err = count(args[2])
+		if err != nil {
+			return ErrorNode{err}
+		}
+	}
+
+	if start > end || end > len(runes) {
+		return ErrorNode{DomainError{fmt.Sprintf("substring %v to %v out of range for string of length %v", start, end, len(runes))}}
+	}
+
+	return StringNode(runes[start:end])
+}
+
+// IndexOf returns the index of the first occurrence of a substring, or -1 if
+// there is none.
+func IndexOf(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	i := strings.Index(s[0], s[1])
+	if i < 0 {
+		return IntNode(-1)
+	}
+
+	return IntNode(utf8.RuneCountInString(s[0][:i]))
+}
+
+func Contains(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	return BoolNode(strings.Contains(s[0], s[1]))
+}
+
+func StartsWith(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	return BoolNode(strings.HasPrefix(s[0], s[1]))
+}
+
+func EndsWith(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	return BoolNode(strings.HasSuffix(s[0], s[1]))
+}
+
+// Split splits a string around every occurrence of a separator. Empty parts
+// are left out, like the standard library's split used to, so "a,,b" gives
+// {"a" "b"}. An empty separator splits it into characters.
+func Split(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(s[0], s[1])
+	nodes := make([]Node, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			nodes = append(nodes, StringNode(part))
+		}
+	}
+
+	return ExpressionNode{Type: QExpression, Nodes: nodes}
+}
+
+// StrJoin joins a list of strings, putting a separator between them.
+func StrJoin(env *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	xs, ok := args[0].(ExpressionNode)
+	if !ok || xs.Type != QExpression {
+		return ErrorNode{typeError("Q-Expression", args[0])}
+	}
+
+	sep, ok := args[1].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[1])}
+	}
+
+	parts := make([]string, len(xs.Nodes))
+	for i, node := range xs.Nodes {
+		s, ok := node.(StringNode)
+		if !ok {
+			return ErrorNode{typeError("String", node)}
+		}
+
+		parts[i] = string(s)
+	}
+
+	if env.limitsLength() && len(parts) > 0 {
+		length := mulLength(utf8.RuneCountInString(string(sep)), len(parts)-1)
+		for _, part := range parts {
+			length = addLength(length, utf8.RuneCountInString(part))
+		}
+
+		if err := env.checkLength(length); err != nil {
+			return ErrorNode{err}
+		}
+	}
+
+	return StringNode(strings.Join(parts, string(sep)))
+}
+
+func Trim(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	return StringNode(strings.TrimSpace(s[0]))
+}
+
+func Upper(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	return StringNode(strings.ToUpper(s[0]))
+}
+
+func Lower(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	return StringNode(strings.ToLower(s[0]))
+}
+
+// Replace replaces every occurrence of a substring.
+func Replace(env *Environment, args []Node) Node {
+	s, err := stringArgs(args, 3)
+	if err != nil {
+		return err
+	}
+
+	if env.limitsLength() {
+		// Every match of the old string grows the result by the difference in
+		// length, an empty one matches around every character.
+		length := utf8.RuneCountInString(s[0])
+		growth := utf8.RuneCountInString(s[2]) - utf8.RuneCountInString(s[1])
+		if growth > 0 {
+			length = addLength(length, mulLength(strings.Count(s[0], s[1]), growth))
+		}
+
+		if err := env.checkLength(length); err != nil {
+			return ErrorNode{err}
+		}
+	}
+
+	return StringNode(strings.ReplaceAll(s[0], s[1], s[2]))
+}
+
+func Repeat(env *Environment, args []Node) Node {
+	if err := checkArity(args, 2, 2); err != nil {
+		return ErrorNode{err}
+	}
+
+	s, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	n, err := count(args[1])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	if err := env.checkLength(mulLength(utf8.RuneCountInString(string(s)), n)); err != nil {
+		return ErrorNode{err}
+	}
+
+	var ret strings.Builder
+	for i := 0; i < n; i++ {
+		if err := env.checkTime(i); err != nil {
+			return ErrorNode{err}
+		}
+
+		ret.WriteString(string(s))
+	}
+
+	return StringNode(ret.String())
+}
+
+// pad implements padLeft and padRight. It pads a string to a width with
+// spaces, or the characters of an optional padding string.
+func pad(env *Environment, args []Node, left bool) Node {
+	if err := checkArity(args, 2, 3); err != nil {
+		return ErrorNode{err}
+	}
+
+	s, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	width, err := count(args[1])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	if err := env.checkLength(width); err != nil {
+		return ErrorNode{err}
+	}
+
+	padding := []rune(" ")
+	if len(args) == 3 {
+		p, ok := args[2].(StringNode)
+		if !ok {
+			return ErrorNode{typeError("String", args[2])}
+		}
+
+		padding = []rune(p)
+		if len(padding) == 0 {
+			return ErrorNode{DomainError{"padding must not be empty"}}
+		}
+	}
+
+	missing := width - utf8.RuneCountInString(string(s))
+	if missing <= 0 {
+		return s
+	}
+
+	fill := make([]rune, missing)
+	for i := range fill {
+		fill[i] = padding[i%len(padding)]
+	}
+
+	if left {
+		return StringNode(fill) + s
+	}
+
+	return s + StringNode(fill)
+}
+
+func PadLeft(env *Environment, args []Node) Node {
+	return pad(env, args, true)
+}
+
+func PadRight(env *Environment, args []Node) Node {
+	return pad(env, args, false)
+}
+
+// display returns how node is shown to users, which is its String() form
+// except for strings, which are shown without quotes.
+func display(node Node) string {
+	s, ok := node.(StringNode)
+	if ok {
+		return string(s)
+	}
+
+	return node.String()
+}
+
+// Str converts its arguments to display strings and joins them.
+func Str(env *Environment, args []Node) Node {
+	parts := make([]string, len(args))
+	length := 0
+	for i, arg := range args {
+		part, err := env.displayNode(arg)
+		if err != nil {
+			return ErrorNode{err}
+		}
+
+		if env.limitsLength() {
+			length = addLength(length, utf8.RuneCountInString(part))
+			if err := env.checkLength(length); err != nil {
+				return ErrorNode{err}
+			}
+		}
+
+		parts[i] = part
+	}
+
+	return StringNode(strings.Join(parts, ""))
+}
+
+// Repr returns the String() form of a node, the way the REPL prints it.
+func Repr(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, 1); err != nil {
+		return ErrorNode{err}
+	}
+
+	s, err := env.printNode(args[0])
+	if err != nil {
+		return ErrorNode{err}
+	}
+
+	return StringNode(s)
+}
+
+func ParseNumberBuiltin(_ *Environment, args []Node) Node {
+	s, err := stringArgs(args, 1)
+	if err != nil {
+		return err
+	}
+
+	n, parseErr := ParseNumber(strings.TrimSpace(s[0]))
+	if parseErr != nil {
+		return ErrorNode{DomainError{fmt.Sprintf("cannot parse %q as a number", s[0])}}
+	}
+
+	return n
+}
+
+// formatVerb formats a single argument for the format builtin. spec is the
+// full directive, like "%5.2f", and verb its last character.
+func formatVerb(env *Environment, spec string, verb byte, arg Node) (string, error) {
+	// The width and precision are the only numbers in spec, and either can
+	// make the result as long as it says.
+	for _, field := range strings.FieldsFunc(spec, func(r rune) bool { return r < '0' || r > '9' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			n = maxInt
+		}
+
+		if err := env.checkLength(n); err != nil {
+			return "", err
+		}
+	}
+
+	switch verb {
+	case 'd':
+		switch v := arg.(type) {
+		case IntNode:
+			return fmt.Sprintf(spec, int64(v)), nil
+		case BigIntNode:
+			return fmt.Sprintf(spec, v.Value), nil
+		default:
+			return "", typeError("Integer", arg)
+		}
+	case 'f', 'e', 'g':
+		if !isNumber(arg) {
+			return "", typeError("Number", arg)
+		}
+
+		return fmt.Sprintf(spec, toFloat(arg)), nil
+	case 's', 'q':
+		s, err := env.displayNode(arg)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf(spec, s), nil
+	case 'v':
+		s, err := env.printNode(arg)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf(spec[:len(spec)-1]+"s", s), nil
+	case 'T':
+		return fmt.Sprintf(spec[:len(spec)-1]+"s", arg.TypeString()), nil
+	default:
+		return "", DomainError{fmt.Sprintf("unknown format verb %%%c", verb)}
+	}
+}
+
+// Format formats its arguments according to a format string. It supports the
+// verbs %d for integers, %f, %e and %g for numbers, %s for display strings,
+// %q for quoted strings, %v for the String() form, %T for the type and %% for
+// a percent sign, with the flags, widths and precisions of Go's fmt package.
+func Format(env *Environment, args []Node) Node {
+	if err := checkArity(args, 1, variadic); err != nil {
+		return ErrorNode{err}
+	}
+
+	f, ok := args[0].(StringNode)
+	if !ok {
+		return ErrorNode{typeError("String", args[0])}
+	}
+
+	format := string(f)
+	args = args[1:]
+
+	var ret strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			ret.WriteByte(format[i])
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+
+		if i >= len(format) {
+			return ErrorNode{DomainError{fmt.Sprintf("incomplete format directive %v", format[start:])}}
+		}
+
+		if format[i] == '%' {
+			ret.WriteByte('%')
+			continue
+		}
+
+		if len(args) == 0 {
+			return ErrorNode{DomainError{fmt.Sprintf("missing argument for %v", format[start:i+1])}}
+		}
+
+		s, err := formatVerb(env, format[start:i+1], format[i], args[0])
+		if err != nil {
+			return ErrorNode{err}
+		}
+
+		ret.WriteString(s)
+		args = args[1:]
+	}
+
+	if len(args) != 0 {
+		return ErrorNode{DomainError{fmt.Sprintf("%v arguments left over after formatting", len(args))}}
+	}
+
+	return StringNode(ret.String())
+}
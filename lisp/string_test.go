@@ -0,0 +1,29 @@
+package lisp
+
+import "testing"
+
+func TestSplit(t *testing.T) {
+	runTests(t, []evalTest{
+		{`split "a,b,c" ","`, `{"a" "b" "c"}`},
+		{`split "a,,b" ","`, `{"a" "b"}`},
+		{`split ",a," ","`, `{"a"}`},
+		{`split "" ","`, `{}`},
+		{`split "héllo" ""`, `{"h" "é" "l" "l" "o"}`},
+	})
+}
+
+func TestStringEnds(t *testing.T) {
+	runTests(t, []evalTest{
+		{`head "héllo"`, `"h"`},
+		{`head "éa"`, `"é"`},
+		{`tail "éa"`, `"a"`},
+		{`post "aé"`, `"é"`},
+		{`init "aé"`, `"a"`},
+		{`first "日本"`, `"日"`},
+		{`last "日本"`, `"本"`},
+		{`tail "a"`, `""`},
+		{`init "é"`, `""`},
+		{`try {head ""} {e} {error-type e}`, `"domain"`},
+		{`try {last ""} {e} {error-type e}`, `"domain"`},
+	})
+}
@@ -3,6 +3,7 @@ package lisp
 import (
 	"fmt"
 	"regexp"
+	"unicode/utf8"
 )
 
 type TokenType uint8
@@ -21,6 +22,10 @@ func (t TokenType) String() string {
 		return "Identifier"
 	case StringToken:
 		return "String"
+	case BoolToken:
+		return "Bool"
+	case QuoteToken:
+		return "Quote"
 	default:
 		return "<unknown>"
 	}
@@ -33,59 +38,133 @@ const (
 	NumberToken
 	StringToken
 	IdentifierToken
+	BoolToken
+	QuoteToken
 )
 
 var Patterns = map[TokenType]*regexp.Regexp{
 	WhitespaceToken: regexp.MustCompile("^\\s+"),
-	OpenToken:       regexp.MustCompile("^[({]"),
+	OpenToken:       regexp.MustCompile("^(?:#\\{|[({])"),
 	CloseToken:      regexp.MustCompile("^[)}]"),
+	QuoteToken:      regexp.MustCompile("^(?:`|,@?)"),
 	NumberToken:     regexp.MustCompile("^[+-]?(\\d+\\.?\\d*|\\.\\d+)"),
 	StringToken:     regexp.MustCompile("^\"(?:[^\\\\\"]|\\\\.)*\""),
-	IdentifierToken: regexp.MustCompile("^[a-zA-Z_+\\-*/\\\\=<>!&%][a-zA-Z0-9_+\\-*/\\\\=<>!&%]*"),
+	IdentifierToken: regexp.MustCompile("^[a-zA-Z_+\\-*/\\\\=<>!?&%][a-zA-Z0-9_+\\-*/\\\\=<>!?&%]*"),
+}
+
+// patternOrder is the order patterns are tried in, so that for example "-5"
+// is a number rather than an identifier.
+var patternOrder = []TokenType{
+	WhitespaceToken,
+	OpenToken,
+	CloseToken,
+	QuoteToken,
+	NumberToken,
+	StringToken,
+	IdentifierToken,
 }
 
 var CommentPattern = regexp.MustCompile("^;.*?(?:[\\n\\r]|$)")
 
+// Position describes a location in a source file. Lines and columns start at
+// 1, columns are counted in runes and Offset is the byte offset into the input.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "<unknown>"
+	}
+
+	if p.File == "" {
+		return fmt.Sprintf("%v:%v", p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%v:%v:%v", p.File, p.Line, p.Column)
+}
+
+// advance returns the position directly after text, assuming text starts at p.
+func (p Position) advance(text string) Position {
+	for _, r := range text {
+		if r == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+
+	p.Offset += len(text)
+	return p
+}
+
 type Token struct {
 	Type  TokenType
 	Value string
+	Pos   Position
 }
 
 func (t Token) String() string {
 	return fmt.Sprintf("%v(%v)", t.Type, t.Value)
 }
 
-type UnexpectedCharacter uint8
+type UnexpectedCharacter struct {
+	Char rune
+	Pos  Position
+}
 
 func (t UnexpectedCharacter) Error() string {
-	return fmt.Sprintf("unexpected character in input: %c", uint8(t))
+	return fmt.Sprintf("%v: unexpected character in input: %c", t.Pos, t.Char)
 }
 
 func Tokenize(input string) ([]Token, error) {
+	return TokenizeSource("", input)
+}
+
+// TokenizeSource tokenizes input, recording file as the source of every token.
+func TokenizeSource(file string, input string) ([]Token, error) {
 	toParse := input
 	tokens := make([]Token, 0)
+	pos := Position{File: file, Line: 1, Column: 1}
 
 	for len(toParse) > 0 {
 		parsed := false
 
 		matches := CommentPattern.FindStringSubmatch(toParse)
 		if len(matches) > 0 {
+			pos = pos.advance(matches[0])
 			toParse = toParse[len(matches[0]):]
 			continue
 		}
 
-		for type_, pattern := range Patterns {
-			matches := pattern.FindStringSubmatch(toParse)
+		for _, type_ := range patternOrder {
+			matches := Patterns[type_].FindStringSubmatch(toParse)
 			if len(matches) > 0 {
 				parsed = true
-				tokens = append(tokens, Token{type_, matches[0]})
+
+				// Booleans are identifiers with reserved names.
+				if type_ == IdentifierToken && (matches[0] == "true" || matches[0] == "false") {
+					type_ = BoolToken
+				}
+
+				tokens = append(tokens, Token{type_, matches[0], pos})
+				pos = pos.advance(matches[0])
 				toParse = toParse[len(matches[0]):]
 				break
 			}
 		}
 
 		if !parsed {
-			return nil, UnexpectedCharacter(toParse[0])
+			char, _ := utf8.DecodeRuneInString(toParse)
+			return nil, UnexpectedCharacter{char, pos}
 		}
 	}
 
@@ -0,0 +1,103 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxFrameArgLength is the length an argument is shortened to when it is
+	// shown in a stack trace.
+	maxFrameArgLength = 24
+	// maxTraceLines is the number of frames shown on either end of a stack
+	// trace before the frames in between are omitted.
+	maxTraceLines = 10
+)
+
+// Frame is a single function call recorded in a stack trace.
+type Frame struct {
+	Name string
+	Pos  Position
+	Args []Node
+}
+
+func shorten(s string) string {
+	runes := []rune(s)
+	if len(runes) <= maxFrameArgLength {
+		return s
+	}
+
+	return string(runes[:maxFrameArgLength-3]) + "..."
+}
+
+func (f Frame) String() string {
+	name := f.Name
+	if name == "" {
+		name = "<lambda>"
+	}
+
+	call := []string{name}
+	for _, arg := range f.Args {
+		call = append(call, shorten(arg.String()))
+	}
+
+	ret := "(" + strings.Join(call, " ") + ")"
+	if f.Pos.IsValid() {
+		ret += " at " + f.Pos.String()
+	}
+
+	return ret
+}
+
+// RuntimeError is an error together with the calls it passed through on its
+// way up, innermost call first.
+type RuntimeError struct {
+	Err   error
+	Trace []Frame
+	// used counts the frames in use in the array behind Trace, which errors
+	// created by withFrame share. Only an error using all of them may append
+	// in place, any other copies its trace first.
+	used *int
+}
+
+func (e *RuntimeError) Error() string {
+	ret := e.Err.Error()
+
+	for i, frame := range e.Trace {
+		if len(e.Trace) > 2*maxTraceLines && i == maxTraceLines {
+			ret += fmt.Sprintf("\n    ... %v more calls", len(e.Trace)-2*maxTraceLines)
+		}
+
+		if len(e.Trace) > 2*maxTraceLines && i >= maxTraceLines && i < len(e.Trace)-maxTraceLines {
+			continue
+		}
+
+		ret += "\n    in " + frame.String()
+	}
+
+	return ret
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// withFrame records that the error passed through the call described by frame.
+// The error itself isn't changed, since it may be caught and raised again.
+func (e ErrorNode) withFrame(frame Frame) ErrorNode {
+	err, ok := e.Error.(*RuntimeError)
+	if !ok {
+		err = &RuntimeError{Err: e.Error}
+	}
+
+	trace, used := err.Trace, err.used
+	if used == nil || *used != len(trace) {
+		trace = append([]Frame(nil), trace...)
+		used = new(int)
+	}
+
+	trace = append(trace, frame)
+	*used = len(trace)
+
+	return ErrorNode{&RuntimeError{Err: err.Err, Trace: trace, used: used}}
+}
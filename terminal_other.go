@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "errors"
+
+func isTerminal(_ int) bool {
+	return false
+}
+
+func makeRaw(_ int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}